@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"time"
+
+	"github.com/nccapo/url-sh/config"
+	"github.com/nccapo/url-sh/internal/analytics"
+	"github.com/nccapo/url-sh/internal/db"
+	"github.com/nccapo/url-sh/internal/store"
+)
+
+// anonymizeBatchSize is the number of access logs rewritten per statement.
+const anonymizeBatchSize = 1000
+
+// runAnonymize rewrites the IP addresses recorded before the privacy mode was enabled,
+// in the access logs and the rolled up visitors, according to the configured mode.
+// It can be interrupted and run again.
+func runAnonymize(ctx context.Context, cfg *config.Config) error {
+	if cfg.AnalyticsConfig.PrivacyMode == config.PrivacyModeOff {
+		return errors.New("privacy mode is off, set APP_PRIVACY_MODE to truncate or hash")
+	}
+
+	dbConn, err := db.NewConn(cfg.DBConfig.Addr, 1, 1, cfg.DBConfig.MaxIdleTime)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+
+	st := store.NewStore(dbConn)
+	anonymizer := analytics.NewAnonymizer(cfg.AnalyticsConfig.PrivacyMode, &backfillSalts{
+		current: st.Privacy,
+		today:   time.Now().UTC().Truncate(24 * time.Hour),
+		past:    map[time.Time][]byte{},
+	})
+
+	var lastID, total int64
+	for {
+		logs, err := st.Privacy.PendingAnonymization(ctx, lastID, anonymizeBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(logs) == 0 {
+			break
+		}
+
+		ids := make([]int64, len(logs))
+		ipAddresses := make([]string, len(logs))
+		for i, log := range logs {
+			ids[i] = log.ID
+			if ipAddresses[i], err = anonymizer.Anonymize(ctx, log.IPAddress, log.AccessedAt); err != nil {
+				return err
+			}
+		}
+
+		if err := st.Privacy.AnonymizeIPs(ctx, ids, ipAddresses); err != nil {
+			return err
+		}
+
+		lastID = ids[len(ids)-1]
+		total += int64(len(ids))
+		config.Info("Anonymized %d access logs", total)
+	}
+
+	config.Info("All access logs are anonymized")
+
+	// Rolled up visitors are anonymized like the access logs of their day, so that
+	// they are still counted once together with them.
+	total = 0
+	for {
+		visitors, err := st.Privacy.PendingVisitorAnonymization(ctx, anonymizeBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(visitors) == 0 {
+			break
+		}
+
+		ipAddresses := make([]string, len(visitors))
+		for i, visitor := range visitors {
+			if ipAddresses[i], err = anonymizer.Anonymize(ctx, visitor.IPAddress, visitor.Day); err != nil {
+				return err
+			}
+		}
+
+		if err := st.Privacy.AnonymizeVisitors(ctx, visitors, ipAddresses); err != nil {
+			return err
+		}
+
+		total += int64(len(visitors))
+		config.Info("Anonymized %d rolled up visitors", total)
+	}
+
+	config.Info("All rolled up visitors are anonymized")
+	return nil
+}
+
+// backfillSalts hashes today's clicks with the stored salt, so that they stay comparable
+// with clicks recorded from now on, and earlier days with salts that are never stored.
+type backfillSalts struct {
+	current analytics.SaltStore
+	today   time.Time
+	past    map[time.Time][]byte
+}
+
+func (b *backfillSalts) DailySalt(ctx context.Context, day time.Time) ([]byte, error) {
+	if !day.Before(b.today) {
+		return b.current.DailySalt(ctx, day)
+	}
+
+	if salt, ok := b.past[day]; ok {
+		return salt, nil
+	}
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	b.past[day] = salt
+	return salt, nil
+}
@@ -1,46 +1,146 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	_ "time/tzdata" // time series requests accept any IANA time zone
 
 	"github.com/nccapo/url-sh/config"
 	"github.com/nccapo/url-sh/internal/db"
+	"github.com/nccapo/url-sh/internal/metrics"
+	"github.com/nccapo/url-sh/internal/retention"
+	"github.com/nccapo/url-sh/internal/rollup"
 	"github.com/nccapo/url-sh/internal/server"
 	"github.com/nccapo/url-sh/internal/store"
+	"github.com/nccapo/url-sh/internal/tracing"
+	"github.com/nccapo/url-sh/internal/webhooks"
 )
 
-func main() {
-	flag.Parse()
+// shutdownTimeout bounds how long in-flight requests and pending spans are given on exit.
+const shutdownTimeout = 10 * time.Second
 
+func main() {
 	flag.Bool("debug", false, "Enable debug mode")
 
+	flag.Parse()
+
 	// initialize application configuration whith default values.
 	cfg, err := config.NewConfig()
 	if err != nil {
 		panic(err)
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(ctx, cfg, flag.Args()[1:]); err != nil {
+			config.Error("%v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "anonymize" {
+		if err := runAnonymize(ctx, cfg); err != nil {
+			config.Error("%v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg.TracingConfig)
+	if err != nil {
+		panic(err)
+	}
+
 	dbConn, err := db.NewConn(cfg.DBConfig.Addr, cfg.DBConfig.MaxOpenConns, cfg.DBConfig.MaxIdleConns, cfg.DBConfig.MaxIdleTime)
 	if err != nil {
 		panic(err)
 	}
 	defer dbConn.Close()
 
+	if cfg.DBConfig.AutoMigrate {
+		if err := autoMigrate(ctx, cfg); err != nil {
+			panic(err)
+		}
+	}
+
+	// Refuse to serve a schema that is dirty or newer than this binary.
+	if err := db.CheckSchema(ctx, dbConn); errors.Is(err, db.ErrSchemaBehind) {
+		config.Warn("%v", err)
+	} else if err != nil {
+		panic(err)
+	}
+
+	if err := metrics.RegisterDBStats(dbConn); err != nil {
+		panic(err)
+	}
+
 	st := store.NewStore(dbConn)
 
 	cfg.Store = &st
 
 	srv := http.Server{
 		Addr:    ":8090",
-		Handler: server.CorsMiddleware(server.Routes(&st)),
+		Handler: server.CorsMiddleware(server.MetricsMiddleware(server.Routes(cfg, dbConn))),
 	}
 
+	srv.RegisterOnShutdown(server.CloseStreams)
+
+	adminSrv := http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.AdminPort),
+		Handler: server.AdminRoutes(),
+	}
+
+	go func() {
+		config.Info("Admin server started on port %s", adminSrv.Addr)
+		if err := adminSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			config.Error("admin server: %v", err)
+		}
+	}()
+
+	go rollup.NewJob(&st, cfg.AnalyticsConfig.RollupInterval).Run(ctx)
+	go retention.NewJob(&st, cfg.RetentionConfig).Run(ctx)
+	go webhooks.NewDispatcher(&st, cfg.WebhookConfig, cfg.SecretKey).Run(ctx)
+
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+
+		<-ctx.Done()
+		config.Info("Shutting down...")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		adminSrv.Shutdown(shutdownCtx)
+		srv.Shutdown(shutdownCtx)
+	}()
+
 	config.Info("Server started on port :8090")
 	config.Info("Press Ctrl+C to stop the server")
 
 	err = srv.ListenAndServe()
-	if err != nil {
+	if err != nil && err != http.ErrServerClosed {
 		panic(err)
 	}
+	<-drained
+
+	// Queue the webhooks of the clicks recorded while the server was draining.
+	server.CloseWebhooks()
+
+	// Flush the spans recorded while the server was draining.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		config.Error("tracing shutdown: %v", err)
+	}
 }
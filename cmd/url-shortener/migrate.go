@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/nccapo/url-sh/config"
+	"github.com/nccapo/url-sh/internal/db"
+	"github.com/nccapo/url-sh/internal/store"
+)
+
+const migrateUsage = "usage: url-shortener migrate up [N] | down N | status | force VERSION | verify"
+
+// runMigrate executes the migrate subcommand with the given arguments.
+func runMigrate(ctx context.Context, cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	if args[0] == "verify" {
+		return verifyQueryPlans(ctx, cfg)
+	}
+
+	m, err := db.NewMigrator(cfg.DBConfig.Addr)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	switch args[0] {
+	case "up":
+		steps, err := optionalInt(args[1:], 0)
+		if err != nil {
+			return err
+		}
+		if err := m.Up(ctx, steps); err != nil {
+			return err
+		}
+	case "down":
+		steps, err := optionalInt(args[1:], -1)
+		if err != nil {
+			return err
+		}
+		if steps < 0 {
+			return errors.New("down requires the number of migrations to roll back")
+		}
+		if err := m.Down(ctx, steps); err != nil {
+			return err
+		}
+	case "force":
+		version, err := optionalInt(args[1:], -2)
+		if err != nil {
+			return err
+		}
+		if version < -1 {
+			return errors.New("force requires a version, -1 means no migration applied")
+		}
+		if err := m.Force(ctx, version); err != nil {
+			return err
+		}
+	case "status":
+	default:
+		return fmt.Errorf("unknown migrate command %q\n%s", args[0], migrateUsage)
+	}
+
+	version, dirty, err := m.Status(ctx)
+	if errors.Is(err, db.ErrNoMigrations) {
+		config.Info("No migrations applied, binary expects version %d", db.SchemaVersion)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	config.Info("Schema version %d (dirty: %t), binary expects version %d", version, dirty, db.SchemaVersion)
+	return nil
+}
+
+// autoMigrate applies the pending embedded migrations on startup.
+func autoMigrate(ctx context.Context, cfg *config.Config) error {
+	m, err := db.NewMigrator(cfg.DBConfig.Addr)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	config.Info("Applying migrations up to version %d", db.SchemaVersion)
+	return m.Up(ctx, 0)
+}
+
+// verifyQueryPlans checks that the analytics queries use the indexes created by the migrations.
+func verifyQueryPlans(ctx context.Context, cfg *config.Config) error {
+	dbConn, err := db.NewConn(cfg.DBConfig.Addr, 1, 1, cfg.DBConfig.MaxIdleTime)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+
+	if err := store.VerifyQueryPlans(ctx, dbConn); err != nil {
+		return err
+	}
+
+	config.Info("All analytics queries use their indexes")
+	return nil
+}
+
+// optionalInt parses the first argument as an integer, or returns def when there is none.
+func optionalInt(args []string, def int) (int, error) {
+	if len(args) == 0 {
+		return def, nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", args[0])
+	}
+	return n, nil
+}
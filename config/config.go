@@ -24,9 +24,24 @@ type Config struct {
 
 	Store *store.Store `json:"store"`
 
+	// TracingConfig is the configuration for OpenTelemetry tracing.
+	TracingConfig *TracingConfig `json:"tracing"`
+
+	// AnalyticsConfig is the configuration for click analytics.
+	AnalyticsConfig *AnalyticsConfig `json:"analytics"`
+	// RetentionConfig is the configuration for the pruning of raw clicks.
+	RetentionConfig *RetentionConfig `json:"retention"`
+	// WebhookConfig is the configuration for the delivery of webhooks.
+	WebhookConfig *WebhookConfig `json:"webhook"`
+	// RedirectConfig is the configuration for the redirects of short URLs.
+	RedirectConfig *RedirectConfig `json:"redirect"`
+
 	// Port is the port to listen on.
 	Port int `json:"port"`
 
+	// AdminPort is the port serving operational endpoints such as metrics.
+	AdminPort int `json:"admin_port"`
+
 	// SecretKey is the secret key to use for signing tokens.
 	SecretKey string `json:"secret_key"`
 
@@ -42,7 +57,8 @@ type Config struct {
 	// MaxRedirects is the maximum number of redirects allowed.
 	MaxRedirects int `json:"max_redirects"`
 
-	// MaxRedirectsPerURL is the maximum number of redirects allowed per URL.
+	// MaxRedirectsPerURL is the click limit of short URLs created without their own,
+	// 0 for unlimited.
 	MaxRedirectsPerURL int `json:"max_redirects_per_url"`
 
 	// MaxRedirectsPerUser is the maximum number of redirects allowed per user.
@@ -55,6 +71,128 @@ type DBConfig struct {
 	MaxOpenConns int           `json:"max_open_conns"`
 	MaxIdleConns int           `json:"max_idle_conns"`
 	MaxIdleTime  time.Duration `json:"max_idle_time"`
+	// AutoMigrate applies the embedded migrations on startup.
+	AutoMigrate bool `json:"auto_migrate"`
+}
+
+// Tracing exporters supported by TracingConfig.
+const (
+	TracingExporterNone   = "none"
+	TracingExporterStdout = "stdout"
+	TracingExporterOTLP   = "otlp"
+)
+
+// TracingConfig is the configuration for OpenTelemetry tracing.
+type TracingConfig struct {
+	// Exporter selects where spans are sent: none, stdout or otlp.
+	Exporter string `json:"exporter"`
+	// Endpoint is the host:port of the OTLP/HTTP collector.
+	Endpoint string `json:"endpoint"`
+	// Insecure disables TLS towards the OTLP collector.
+	Insecure bool `json:"insecure"`
+	// ServiceName is reported as the service.name resource attribute.
+	ServiceName string `json:"service_name"`
+	// SampleRatio is the fraction of root traces that are sampled.
+	SampleRatio float64 `json:"sample_ratio"`
+}
+
+// Privacy modes supported by AnalyticsConfig.
+const (
+	// PrivacyModeOff stores client IP addresses as received.
+	PrivacyModeOff = "off"
+	// PrivacyModeTruncate stores IPv4 addresses truncated to /24 and IPv6 addresses to /48.
+	PrivacyModeTruncate = "truncate"
+	// PrivacyModeHash stores a salted hash of IP addresses, with a salt rotated daily.
+	PrivacyModeHash = "hash"
+)
+
+// AnalyticsConfig is the configuration for click analytics.
+type AnalyticsConfig struct {
+	// StripReferrerQuery drops the query string of recorded referrers.
+	StripReferrerQuery bool `json:"strip_referrer_query"`
+	// GeoIPDatabase is the path of a MaxMind-format (MMDB) city or country database
+	// clicks are geolocated with. Geolocation is disabled if it is empty.
+	GeoIPDatabase string `json:"geoip_database"`
+	// PrivacyMode selects how client IP addresses are stored: off, truncate or hash.
+	PrivacyMode string `json:"privacy_mode"`
+	// RollupInterval is the time between two runs of the job rolling up the clicks
+	// of past days into the daily rollups.
+	RollupInterval time.Duration `json:"rollup_interval"`
+	// StreamBuffer is the number of click events buffered per stream subscriber.
+	// Events are dropped for subscribers that fall further behind.
+	StreamBuffer int `json:"stream_buffer"`
+	// StreamNotify relays click events through Postgres LISTEN/NOTIFY, so that
+	// streams receive the clicks recorded by every replica.
+	StreamNotify bool `json:"stream_notify"`
+}
+
+// Retention modes supported by RetentionConfig.
+const (
+	// RetentionModeRollup aggregates expired clicks into daily rollups before deleting them.
+	RetentionModeRollup = "rollup"
+	// RetentionModeDelete deletes expired clicks.
+	RetentionModeDelete = "delete"
+)
+
+// RetentionConfig is the configuration for the pruning of raw clicks.
+type RetentionConfig struct {
+	// Days is how long raw clicks are kept for short URLs without their own
+	// retention, 0 keeps them forever.
+	Days int `json:"days"`
+	// Mode selects what happens to expired clicks: rollup or delete.
+	Mode string `json:"mode"`
+	// Interval is the time between two pruning runs.
+	Interval time.Duration `json:"interval"`
+	// BatchSize is the number of rows deleted per statement.
+	BatchSize int `json:"batch_size"`
+	// DeliveryDays is how long delivered and failed webhook deliveries are kept,
+	// 0 keeps them forever.
+	DeliveryDays int `json:"delivery_days"`
+}
+
+// WebhookConfig is the configuration for the delivery of webhooks.
+type WebhookConfig struct {
+	// Timeout bounds a single delivery attempt.
+	Timeout time.Duration `json:"timeout"`
+	// MaxAttempts is the number of attempts after which a delivery is marked as failed.
+	MaxAttempts int `json:"max_attempts"`
+	// PollInterval is the time between two checks for due deliveries.
+	PollInterval time.Duration `json:"poll_interval"`
+}
+
+// RedirectConfig is the configuration for the redirects of short URLs.
+type RedirectConfig struct {
+	// Status is the HTTP status of redirects for short URLs without their own:
+	// 301, 302, 307 or 308.
+	Status int `json:"status"`
+	// CacheMaxAge is how long browsers and proxies may cache the redirects of short
+	// URLs that opt in to caching. Other redirects are never cached.
+	CacheMaxAge time.Duration `json:"cache_max_age"`
+	// UnlockTTL is how long a password-protected short URL stays unlocked in the
+	// browser that entered its password.
+	UnlockTTL time.Duration `json:"unlock_ttl"`
+	// UnlockMaxAttempts is the number of wrong passwords accepted per short URL
+	// within UnlockWindow, further attempts are rejected until the window ends.
+	// They are counted for all visitors together, so wrong passwords lock out the
+	// visitors who know the right one as well.
+	UnlockMaxAttempts int `json:"unlock_max_attempts"`
+	// UnlockWindow is the period wrong passwords are counted over.
+	UnlockWindow time.Duration `json:"unlock_window"`
+	// ScheduledFallbackURL is where visitors of short URLs whose activation window
+	// hasn't started are sent, unless the short URL has its own. A "not yet
+	// available" page is served if it is empty.
+	ScheduledFallbackURL string `json:"scheduled_fallback_url"`
+	// EndedFallbackURL is where visitors of short URLs whose activation window has
+	// ended are sent, unless the short URL has its own. A "no longer available"
+	// page is served if it is empty.
+	EndedFallbackURL string `json:"ended_fallback_url"`
+	// VariantCookieTTL is how long a visitor keeps being served the same variant of
+	// short URLs with sticky variants.
+	VariantCookieTTL time.Duration `json:"variant_cookie_ttl"`
+	// UTMPolicy decides what happens to the UTM and tracking parameters the
+	// destination already has, for short URLs without their own policy: override
+	// replaces them, keep leaves them as they are.
+	UTMPolicy string `json:"utm_policy"`
 }
 
 // defaultConfig returns a default Config instance.
@@ -68,14 +206,54 @@ func defaultConfig() *Config {
 			MaxOpenConns: getEnvInt("DB_MAX_OPEN_CONNS", maxOpenCons),
 			MaxIdleConns: getEnvInt("DB_MAX_IDLE_CONNS", maxIdleCons),
 			MaxIdleTime:  getEnvDuration("DB_MAX_IDLE_TIME", idleTime),
+			AutoMigrate:  getEnvBool("DB_AUTO_MIGRATE", false),
+		},
+		TracingConfig: &TracingConfig{
+			Exporter:    getEnvString("APP_TRACING_EXPORTER", TracingExporterNone),
+			Endpoint:    getEnvString("APP_TRACING_ENDPOINT", "localhost:4318"),
+			Insecure:    getEnvBool("APP_TRACING_INSECURE", false),
+			ServiceName: getEnvString("APP_TRACING_SERVICE_NAME", "url-sh"),
+			SampleRatio: getEnvFloat("APP_TRACING_SAMPLE_RATIO", 1),
+		},
+		AnalyticsConfig: &AnalyticsConfig{
+			StripReferrerQuery: getEnvBool("APP_REFERRER_STRIP_QUERY", true),
+			GeoIPDatabase:      getEnvString("APP_GEOIP_DATABASE", ""),
+			PrivacyMode:        getEnvString("APP_PRIVACY_MODE", PrivacyModeOff),
+			RollupInterval:     getEnvDuration("APP_ROLLUP_INTERVAL", 15*time.Minute),
+			StreamBuffer:       getEnvInt("APP_STREAM_BUFFER", 64),
+			StreamNotify:       getEnvBool("APP_STREAM_NOTIFY", false),
+		},
+		RetentionConfig: &RetentionConfig{
+			Days:         getEnvInt("APP_RETENTION_DAYS", 0),
+			Mode:         getEnvString("APP_RETENTION_MODE", RetentionModeRollup),
+			Interval:     getEnvDuration("APP_RETENTION_INTERVAL", time.Hour),
+			BatchSize:    getEnvInt("APP_RETENTION_BATCH_SIZE", 1000),
+			DeliveryDays: getEnvInt("APP_RETENTION_DELIVERY_DAYS", 30),
+		},
+		WebhookConfig: &WebhookConfig{
+			Timeout:      getEnvDuration("APP_WEBHOOK_TIMEOUT", 10*time.Second),
+			MaxAttempts:  getEnvInt("APP_WEBHOOK_MAX_ATTEMPTS", 10),
+			PollInterval: getEnvDuration("APP_WEBHOOK_POLL_INTERVAL", 5*time.Second),
+		},
+		RedirectConfig: &RedirectConfig{
+			Status:               getEnvInt("APP_REDIRECT_STATUS", 302),
+			CacheMaxAge:          getEnvDuration("APP_REDIRECT_CACHE_MAX_AGE", 24*time.Hour),
+			UnlockTTL:            getEnvDuration("APP_UNLOCK_TTL", time.Hour),
+			UnlockMaxAttempts:    getEnvInt("APP_UNLOCK_MAX_ATTEMPTS", 5),
+			UnlockWindow:         getEnvDuration("APP_UNLOCK_WINDOW", 15*time.Minute),
+			ScheduledFallbackURL: getEnvString("APP_SCHEDULED_FALLBACK_URL", ""),
+			EndedFallbackURL:     getEnvString("APP_ENDED_FALLBACK_URL", ""),
+			VariantCookieTTL:     getEnvDuration("APP_VARIANT_COOKIE_TTL", 30*24*time.Hour),
+			UTMPolicy:            getEnvString("APP_UTM_POLICY", "override"),
 		},
 		Port:                getEnvInt("APP_PORT", 8080),
+		AdminPort:           getEnvInt("APP_ADMIN_PORT", 9090),
 		SecretKey:           getEnvString("APP_SECRET_KEY", "secret_key"),
 		BaseURL:             getEnvString("APP_BASE_URL", "http://localhost:8080"),
 		MaxURLsPerUser:      getEnvInt("APP_MAX_URLS_PER_USER", 100),
 		MaxURLLength:        getEnvInt("APP_MAX_URL_LENGTH", 2048),
 		MaxRedirects:        getEnvInt("APP_MAX_REDIRECTS", 10),
-		MaxRedirectsPerURL:  getEnvInt("APP_MAX_REDIRECTS_PER_URL", 5),
+		MaxRedirectsPerURL:  getEnvInt("APP_MAX_REDIRECTS_PER_URL", 0),
 		MaxRedirectsPerUser: getEnvInt("APP_MAX_REDIRECTS_PER_USER", 5),
 	}
 }
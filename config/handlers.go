@@ -6,6 +6,11 @@ func (c *Config) GetPort() int {
 	return c.Port
 }
 
+// GetAdminPort returns the admin port
+func (c *Config) GetAdminPort() int {
+	return c.AdminPort
+}
+
 // GetSecretKey returns the secret key
 func (c *Config) GetSecretKey() string {
 	return c.SecretKey
@@ -48,6 +53,13 @@ func WithDatabaseURL(url string) Option {
 	}
 }
 
+// WithAutoMigrate configures whether migrations are applied on startup.
+func WithAutoMigrate(enabled bool) Option {
+	return func(c *Config) {
+		c.DBConfig.AutoMigrate = enabled
+	}
+}
+
 // WithPort configures the port.
 func WithPort(port int) Option {
 	return func(c *Config) {
@@ -55,6 +67,13 @@ func WithPort(port int) Option {
 	}
 }
 
+// WithAdminPort configures the admin port.
+func WithAdminPort(port int) Option {
+	return func(c *Config) {
+		c.AdminPort = port
+	}
+}
+
 // WithSecretKey configures the secret key.
 func WithSecretKey(key string) Option {
 	return func(c *Config) {
@@ -76,7 +95,7 @@ func WithMaxRedirects(maxRedirects int) Option {
 	}
 }
 
-// WithMaxRedirectsPerURL configures the maximum number of redirects allowed per URL.
+// WithMaxRedirectsPerURL configures the default click limit of short URLs, 0 for unlimited.
 func WithMaxRedirectsPerURL(maxRedirectsPerURL int) Option {
 	return func(c *Config) {
 		c.MaxRedirectsPerURL = maxRedirectsPerURL
@@ -89,3 +108,47 @@ func WithMaxRedirectsPerUser(maxRedirectsPerUser int) Option {
 		c.MaxRedirectsPerUser = maxRedirectsPerUser
 	}
 }
+
+// WithTracingExporter configures the tracing exporter and its endpoint.
+func WithTracingExporter(exporter, endpoint string) Option {
+	return func(c *Config) {
+		c.TracingConfig.Exporter = exporter
+		c.TracingConfig.Endpoint = endpoint
+	}
+}
+
+// WithStripReferrerQuery configures whether query strings are dropped from recorded referrers.
+func WithStripReferrerQuery(strip bool) Option {
+	return func(c *Config) {
+		c.AnalyticsConfig.StripReferrerQuery = strip
+	}
+}
+
+// WithGeoIPDatabase configures the MaxMind-format database clicks are geolocated with.
+func WithGeoIPDatabase(path string) Option {
+	return func(c *Config) {
+		c.AnalyticsConfig.GeoIPDatabase = path
+	}
+}
+
+// WithPrivacyMode configures how client IP addresses are stored: off, truncate or hash.
+func WithPrivacyMode(mode string) Option {
+	return func(c *Config) {
+		c.AnalyticsConfig.PrivacyMode = mode
+	}
+}
+
+// WithRetention configures how many days raw clicks are kept and what happens to them afterwards.
+func WithRetention(days int, mode string) Option {
+	return func(c *Config) {
+		c.RetentionConfig.Days = days
+		c.RetentionConfig.Mode = mode
+	}
+}
+
+// WithRedirectStatus configures the redirect status of short URLs without their own.
+func WithRedirectStatus(status int) Option {
+	return func(c *Config) {
+		c.RedirectConfig.Status = status
+	}
+}
@@ -34,11 +34,11 @@ func PrintValidationMessages(messages []ConfigMessage) error {
 	for _, msg := range messages {
 		switch msg.Level {
 		case INFO:
-			Info(msg.Message)
+			Info("%s", msg.Message)
 		case WARN:
-			Warn(msg.Message)
+			Warn("%s", msg.Message)
 		case ERROR:
-			Error(msg.Message)
+			Error("%s", msg.Message)
 			hasErrors = true
 		}
 	}
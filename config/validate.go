@@ -3,6 +3,8 @@ package config
 
 import (
 	"strings"
+
+	"github.com/nccapo/url-sh/internal/targeting"
 )
 
 var (
@@ -28,6 +30,13 @@ func (c *Config) validate() []ConfigMessage {
 		messages = append(messages, newConfigMessage(ERROR, "port must be between 1 and 65535"))
 	}
 
+	// Admin port validation
+	if c.AdminPort <= 0 || c.AdminPort > 65535 {
+		messages = append(messages, newConfigMessage(ERROR, "admin port must be between 1 and 65535"))
+	} else if c.AdminPort == c.Port {
+		messages = append(messages, newConfigMessage(ERROR, "admin port must differ from the application port"))
+	}
+
 	// Secret key validation
 	if c.SecretKey == "" {
 		messages = append(messages, newConfigMessage(ERROR, "secret key is required"))
@@ -45,6 +54,67 @@ func (c *Config) validate() []ConfigMessage {
 	// Limits validation
 	messages = append(messages, c.validateLimits()...)
 
+	// Tracing validation
+	messages = append(messages, c.validateTracing()...)
+
+	// Rollup interval validation
+	if c.AnalyticsConfig.RollupInterval <= 0 {
+		messages = append(messages, newConfigMessage(ERROR, "rollup interval must be greater than 0"))
+	}
+
+	// Stream buffer validation
+	if c.AnalyticsConfig.StreamBuffer <= 0 {
+		messages = append(messages, newConfigMessage(ERROR, "stream buffer must be greater than 0"))
+	}
+
+	// Retention validation
+	messages = append(messages, c.validateRetention()...)
+
+	// Webhook validation
+	if c.WebhookConfig.Timeout <= 0 || c.WebhookConfig.PollInterval <= 0 {
+		messages = append(messages, newConfigMessage(ERROR, "webhook timeout and poll interval must be greater than 0"))
+	}
+	if c.WebhookConfig.MaxAttempts <= 0 {
+		messages = append(messages, newConfigMessage(ERROR, "webhook max attempts must be greater than 0"))
+	}
+
+	// Redirect validation
+	switch c.RedirectConfig.Status {
+	case 301, 302, 307, 308:
+	default:
+		messages = append(messages, newConfigMessage(ERROR, "redirect status must be one of 301, 302, 307 or 308, got %d", c.RedirectConfig.Status))
+	}
+	if c.RedirectConfig.CacheMaxAge <= 0 {
+		messages = append(messages, newConfigMessage(ERROR, "redirect cache max age must be greater than 0"))
+	}
+	if c.RedirectConfig.UnlockTTL <= 0 || c.RedirectConfig.UnlockWindow <= 0 {
+		messages = append(messages, newConfigMessage(ERROR, "unlock TTL and window must be greater than 0"))
+	}
+	if c.RedirectConfig.UnlockMaxAttempts <= 0 {
+		messages = append(messages, newConfigMessage(ERROR, "unlock max attempts must be greater than 0"))
+	}
+	if c.RedirectConfig.VariantCookieTTL <= 0 {
+		messages = append(messages, newConfigMessage(ERROR, "variant cookie TTL must be greater than 0"))
+	}
+	switch c.RedirectConfig.UTMPolicy {
+	case "override", "keep":
+	default:
+		messages = append(messages, newConfigMessage(ERROR, "UTM policy must be override or keep, got %q", c.RedirectConfig.UTMPolicy))
+	}
+	if fallback := c.RedirectConfig.ScheduledFallbackURL; fallback != "" && !targeting.IsHTTPURL(fallback) {
+		messages = append(messages, newConfigMessage(ERROR, "scheduled fallback URL must be an absolute http or https URL, got %q", fallback))
+	}
+	if fallback := c.RedirectConfig.EndedFallbackURL; fallback != "" && !targeting.IsHTTPURL(fallback) {
+		messages = append(messages, newConfigMessage(ERROR, "ended fallback URL must be an absolute http or https URL, got %q", fallback))
+	}
+
+	// Privacy mode validation
+	switch c.AnalyticsConfig.PrivacyMode {
+	case PrivacyModeOff, PrivacyModeTruncate, PrivacyModeHash:
+	default:
+		messages = append(messages, newConfigMessage(ERROR, "privacy mode must be one of off, truncate or hash, got %q", c.AnalyticsConfig.PrivacyMode))
+	}
+
 	return messages
 }
 
@@ -72,5 +142,58 @@ func (c *Config) validateLimits() []ConfigMessage {
 		messages = append(messages, newConfigMessage(WARN, "max redirects (%d) is very high, might impact performance", c.MaxRedirects))
 	}
 
+	// MaxRedirectsPerURL validation
+	if c.MaxRedirectsPerURL < 0 {
+		messages = append(messages, newConfigMessage(ERROR, "max redirects per URL must not be negative"))
+	}
+
+	return messages
+}
+
+func (c *Config) validateTracing() []ConfigMessage {
+	var messages []ConfigMessage
+
+	switch c.TracingConfig.Exporter {
+	case TracingExporterNone, TracingExporterStdout:
+	case TracingExporterOTLP:
+		if c.TracingConfig.Endpoint == "" {
+			messages = append(messages, newConfigMessage(ERROR, "tracing endpoint is required for the otlp exporter"))
+		}
+	default:
+		messages = append(messages, newConfigMessage(ERROR, "tracing exporter must be one of none, stdout or otlp, got %q", c.TracingConfig.Exporter))
+	}
+
+	if c.TracingConfig.SampleRatio < 0 || c.TracingConfig.SampleRatio > 1 {
+		messages = append(messages, newConfigMessage(ERROR, "tracing sample ratio must be between 0 and 1"))
+	}
+
+	return messages
+}
+
+func (c *Config) validateRetention() []ConfigMessage {
+	var messages []ConfigMessage
+
+	if c.RetentionConfig.Days < 0 {
+		messages = append(messages, newConfigMessage(ERROR, "retention days must not be negative"))
+	}
+
+	switch c.RetentionConfig.Mode {
+	case RetentionModeRollup, RetentionModeDelete:
+	default:
+		messages = append(messages, newConfigMessage(ERROR, "retention mode must be rollup or delete, got %q", c.RetentionConfig.Mode))
+	}
+
+	if c.RetentionConfig.Interval <= 0 {
+		messages = append(messages, newConfigMessage(ERROR, "retention interval must be greater than 0"))
+	}
+
+	if c.RetentionConfig.BatchSize <= 0 {
+		messages = append(messages, newConfigMessage(ERROR, "retention batch size must be greater than 0"))
+	}
+
+	if c.RetentionConfig.DeliveryDays < 0 {
+		messages = append(messages, newConfigMessage(ERROR, "retention delivery days must not be negative"))
+	}
+
 	return messages
 }
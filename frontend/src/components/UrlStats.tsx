@@ -58,6 +58,24 @@ type UniqueIPsResponse = {
   unique_ips: string[];
 };
 
+type ClickBucket = {
+  start: string;
+  clicks: number;
+  unique_visitors: number;
+};
+
+type ClickSeriesResponse = {
+  clicks: {
+    interval: string;
+    timezone: string;
+    total_clicks: number;
+    unique_visitors: number;
+    buckets: ClickBucket[];
+  };
+};
+
+type SeriesInterval = "hour" | "day" | "week";
+
 const UrlStats: Component = () => {
   const navigate = useNavigate();
   const params = useParams();
@@ -69,6 +87,11 @@ const UrlStats: Component = () => {
   >(null);
   const [topUserAgents, setTopUserAgents] = createSignal<string[]>([]);
   const [uniqueIPs, setUniqueIPs] = createSignal<string[]>([]);
+  const [clickSeries, setClickSeries] = createSignal<
+    ClickSeriesResponse["clicks"] | null
+  >(null);
+  const [seriesInterval, setSeriesInterval] =
+    createSignal<SeriesInterval>("day");
   const [isLoading, setIsLoading] = createSignal<boolean>(true);
   const [error, setError] = createSignal<string>("");
   const [searchUrl, setSearchUrl] = createSignal<string>("");
@@ -145,6 +168,9 @@ const UrlStats: Component = () => {
         const uniqueIPsData: UniqueIPsResponse = await uniqueIPsResponse.json();
         setUniqueIPs(uniqueIPsData.unique_ips);
       }
+
+      // Fetch clicks over time
+      await fetchClickSeries(code, seriesInterval());
     } catch (error) {
       console.error("Error fetching URL statistics:", error);
       setError("Failed to load URL statistics");
@@ -154,6 +180,48 @@ const UrlStats: Component = () => {
     }
   };
 
+  const fetchClickSeries = async (code: string, interval: SeriesInterval) => {
+    try {
+      const timezone = Intl.DateTimeFormat().resolvedOptions().timeZone;
+      const response = await fetch(
+        `http://localhost:8090/v1/shorten/clicks?q=${code}&interval=${interval}&tz=${encodeURIComponent(
+          timezone
+        )}`,
+        {
+          method: "GET",
+          headers: {
+            "Content-Type": "application/json",
+          },
+        }
+      );
+
+      if (response.ok) {
+        const data: ClickSeriesResponse = await response.json();
+        setClickSeries(data.clicks);
+      }
+    } catch (error) {
+      console.error("Error fetching click series:", error);
+    }
+  };
+
+  const handleIntervalChange = (interval: SeriesInterval) => {
+    setSeriesInterval(interval);
+    if (urlStats()?.short_code) {
+      fetchClickSeries(urlStats()!.short_code, interval);
+    }
+  };
+
+  const formatBucket = (start: string, interval: string): string => {
+    const date = new Date(start);
+    if (interval === "hour") {
+      return date.toLocaleString("en-US", { hour: "2-digit", day: "numeric" });
+    }
+    return date.toLocaleDateString("en-US", { month: "short", day: "numeric" });
+  };
+
+  const maxBucketClicks = (): number =>
+    Math.max(1, ...(clickSeries()?.buckets.map((b) => b.clicks) ?? [0]));
+
   const findByShortUrl = async (shortUrl: string) => {
     setIsLoading(true);
     setError("");
@@ -192,21 +260,10 @@ const UrlStats: Component = () => {
     }
   };
 
-  const handleVisit = async (shortCode: string) => {
-    try {
-      // First increment the visit count
-      await fetch(`http://localhost:8090/v1/shorten/${shortCode}`, {
-        method: "PUT",
-        headers: {
-          "Content-Type": "application/json",
-        },
-      });
-
-      // Then refresh the stats
-      fetchStats(shortCode);
-    } catch (error) {
-      console.error("Error tracking visit:", error);
-    }
+  const handleVisit = (shortCode: string) => {
+    // The visit is counted by the short URL opened in the new tab, refresh the
+    // stats once it had time to redirect
+    setTimeout(() => fetchStats(shortCode), 1000);
   };
 
   const handleRefresh = () => {
@@ -443,7 +500,7 @@ const UrlStats: Component = () => {
                     <Button
                       variant="text"
                       component="a"
-                      href={urlStats()?.original_url}
+                      href={urlStats()?.short_url}
                       target="_blank"
                       rel="noopener noreferrer"
                       startIcon={<OpenInNewIcon />}
@@ -666,6 +723,89 @@ const UrlStats: Component = () => {
               </Card>
             </Grid>
 
+            {/* Clicks Over Time */}
+            <Grid item xs={12}>
+              <Card sx={{ height: "100%" }}>
+                <CardContent>
+                  <Box
+                    sx={{
+                      display: "flex",
+                      justifyContent: "space-between",
+                      alignItems: "center",
+                      mb: 2,
+                    }}
+                  >
+                    <Typography
+                      variant="h6"
+                      gutterBottom
+                      sx={{ color: "primary.main" }}
+                    >
+                      Clicks Over Time
+                    </Typography>
+                    <Box sx={{ display: "flex", gap: 1 }}>
+                      <For each={["hour", "day", "week"] as SeriesInterval[]}>
+                        {(interval) => (
+                          <Chip
+                            label={interval}
+                            size="small"
+                            color={
+                              seriesInterval() === interval
+                                ? "primary"
+                                : "default"
+                            }
+                            onClick={() => handleIntervalChange(interval)}
+                          />
+                        )}
+                      </For>
+                    </Box>
+                  </Box>
+                  {clickSeries() && clickSeries()!.buckets.length > 0 ? (
+                    <Box>
+                      <Box
+                        sx={{
+                          display: "flex",
+                          alignItems: "flex-end",
+                          gap: "2px",
+                          height: 160,
+                          mb: 1,
+                        }}
+                      >
+                        <For each={clickSeries()!.buckets}>
+                          {(bucket) => (
+                            <Box
+                              title={`${formatBucket(
+                                bucket.start,
+                                clickSeries()!.interval
+                              )}: ${bucket.clicks} clicks, ${
+                                bucket.unique_visitors
+                              } unique`}
+                              sx={{
+                                flex: 1,
+                                minHeight: "1px",
+                                height: `${
+                                  (bucket.clicks / maxBucketClicks()) * 100
+                                }%`,
+                                bgcolor: "primary.main",
+                              }}
+                            />
+                          )}
+                        </For>
+                      </Box>
+                      <Typography variant="body2" color="text.secondary">
+                        {clickSeries()!.total_clicks} clicks,{" "}
+                        {clickSeries()!.unique_visitors} unique visitors (
+                        {clickSeries()!.timezone})
+                      </Typography>
+                    </Box>
+                  ) : (
+                    <Typography variant="body2" color="text.secondary">
+                      No click data available
+                    </Typography>
+                  )}
+                </CardContent>
+              </Card>
+            </Grid>
+
             {/* Unique IPs */}
             <Grid item xs={12}>
               <Card sx={{ height: "100%" }}>
@@ -0,0 +1,49 @@
+package analytics
+
+import (
+	_ "embed"
+	"net/http"
+	"strings"
+)
+
+// botSignatures holds the maintained list of user agent substrings of automated clients.
+//
+//go:embed bots.txt
+var botSignatures string
+
+// signatures are the lower-cased entries of botSignatures.
+var signatures = parseSignatures(botSignatures)
+
+func parseSignatures(list string) []string {
+	var parsed []string
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parsed = append(parsed, strings.ToLower(line))
+	}
+	return parsed
+}
+
+// IsBot classifies a click as automated. A request is a bot when:
+//   - it is a HEAD request, which browsers never send when following a link,
+//   - its User-Agent is empty, recognized as a crawler by the parser or matches bots.txt,
+//   - it carries neither Accept nor Accept-Language, which every browser sends.
+func IsBot(r *http.Request, ua UserAgent) bool {
+	if r.Method == http.MethodHead {
+		return true
+	}
+
+	raw := strings.ToLower(r.UserAgent())
+	if raw == "" || ua.DeviceType == DeviceBot {
+		return true
+	}
+	for _, signature := range signatures {
+		if strings.Contains(raw, signature) {
+			return true
+		}
+	}
+
+	return r.Header.Get("Accept") == "" && r.Header.Get("Accept-Language") == ""
+}
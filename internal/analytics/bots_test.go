@@ -0,0 +1,56 @@
+package analytics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsBot(t *testing.T) {
+	const chrome = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36"
+
+	tests := []struct {
+		name    string
+		method  string
+		ua      string
+		headers map[string]string
+		want    bool
+	}{
+		{"browser", http.MethodGet, chrome, map[string]string{"Accept": "text/html", "Accept-Language": "en"}, false},
+		{"browser without accept-language", http.MethodGet, chrome, map[string]string{"Accept": "text/html"}, false},
+		{"head request", http.MethodHead, chrome, map[string]string{"Accept": "text/html"}, true},
+		{"empty user agent", http.MethodGet, "", map[string]string{"Accept": "text/html"}, true},
+		{"crawler", http.MethodGet, "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", map[string]string{"Accept": "*/*"}, true},
+		{"listed signature", http.MethodGet, "WhatsApp/2.23.20.0", map[string]string{"Accept": "*/*"}, true},
+		{"listed signature in a browser user agent", http.MethodGet, chrome + " facebookexternalhit/1.1", map[string]string{"Accept": "*/*"}, true},
+		{"signatures ignore case", http.MethodGet, "Mozilla/5.0 (compatible; UptimeRobot/2.0)", map[string]string{"Accept": "*/*"}, true},
+		{"no accept headers", http.MethodGet, chrome, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/code", nil)
+			r.Header.Set("User-Agent", tt.ua)
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+
+			if got := IsBot(r, ParseUserAgent(tt.ua)); got != tt.want {
+				t.Errorf("IsBot = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSignatures(t *testing.T) {
+	got := parseSignatures("# comment\n\n  SlackBot \nfacebookexternalhit\n")
+	want := []string{"slackbot", "facebookexternalhit"}
+
+	if len(got) != len(want) {
+		t.Fatalf("parseSignatures = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("parseSignatures = %q, want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package analytics
+
+import (
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// geoIPCheckInterval is how often the database file is checked for changes.
+const geoIPCheckInterval = time.Minute
+
+// Location is the geographic location an IP address resolves to.
+type Location struct {
+	// CountryCode is the ISO 3166-1 alpha-2 code of the country.
+	CountryCode string
+	// RegionCode is the ISO 3166-2 subdivision code within the country, e.g. "CA" for California.
+	RegionCode string
+	// City is the English name of the city.
+	City string
+}
+
+// geoRecord is the subset of a MaxMind GeoIP2/GeoLite2 City or Country record we use.
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+// GeoIP resolves IP addresses against a local MaxMind-format database. The file is
+// reopened when its modification time or size changes, so it can be updated in place.
+//
+// A nil *GeoIP is valid and resolves every address to the zero Location.
+type GeoIP struct {
+	path string
+
+	mu      sync.RWMutex
+	reader  *maxminddb.Reader
+	modTime time.Time
+	size    int64
+
+	// checkedAt is the unix nano time the file was last checked for changes.
+	checkedAt atomic.Int64
+	// OnReload is called after every attempt to reopen a changed database.
+	OnReload func(err error)
+}
+
+// OpenGeoIP opens the database at path. It returns nil without error if path is empty.
+func OpenGeoIP(path string) (*GeoIP, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	g := &GeoIP{path: path}
+	if err := g.reload(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Lookup resolves ip. Unparsable, private and unknown addresses yield the zero Location.
+func (g *GeoIP) Lookup(ip string) Location {
+	if g == nil {
+		return Location{}
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil || parsed.IsPrivate() || parsed.IsLoopback() || parsed.IsUnspecified() {
+		return Location{}
+	}
+
+	g.checkForUpdate()
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	var record geoRecord
+	if err := g.reader.Lookup(parsed, &record); err != nil {
+		return Location{}
+	}
+
+	location := Location{
+		CountryCode: record.Country.ISOCode,
+		City:        record.City.Names["en"],
+	}
+	if len(record.Subdivisions) > 0 {
+		location.RegionCode = record.Subdivisions[0].ISOCode
+	}
+	return location
+}
+
+// Close releases the database.
+func (g *GeoIP) Close() error {
+	if g == nil {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.reader.Close()
+}
+
+// checkForUpdate reopens the database if the file changed, at most once per geoIPCheckInterval.
+// Lookups keep using the previous database if the new file can't be opened.
+func (g *GeoIP) checkForUpdate() {
+	now := time.Now().UnixNano()
+	last := g.checkedAt.Load()
+	if now-last < int64(geoIPCheckInterval) || !g.checkedAt.CompareAndSwap(last, now) {
+		return
+	}
+
+	info, err := os.Stat(g.path)
+	if err != nil {
+		g.reloaded(err)
+		return
+	}
+
+	g.mu.RLock()
+	changed := !info.ModTime().Equal(g.modTime) || info.Size() != g.size
+	g.mu.RUnlock()
+
+	if changed {
+		g.reloaded(g.reload())
+	}
+}
+
+func (g *GeoIP) reloaded(err error) {
+	if g.OnReload != nil {
+		g.OnReload(err)
+	}
+}
+
+// reload opens the database file and swaps it in for the current one.
+func (g *GeoIP) reload() error {
+	info, err := os.Stat(g.path)
+	if err != nil {
+		return err
+	}
+
+	// The file is read into memory rather than mapped, so that it can be
+	// overwritten in place without corrupting lookups in flight.
+	data, err := os.ReadFile(g.path)
+	if err != nil {
+		return err
+	}
+	reader, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	previous := g.reader
+	g.reader, g.modTime, g.size = reader, info.ModTime(), info.Size()
+	g.checkedAt.Store(time.Now().UnixNano())
+	g.mu.Unlock()
+
+	if previous != nil {
+		return previous.Close()
+	}
+	return nil
+}
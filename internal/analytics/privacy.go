@@ -0,0 +1,108 @@
+package analytics
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/nccapo/url-sh/config"
+)
+
+// HashedIPPrefix marks IP addresses replaced by HashIP.
+const HashedIPPrefix = "h:"
+
+// Prefix lengths IP addresses are truncated to.
+const (
+	ipv4PrefixBits = 24
+	ipv6PrefixBits = 48
+)
+
+// SaltStore provides the salt IP addresses are hashed with on a given day.
+type SaltStore interface {
+	DailySalt(ctx context.Context, day time.Time) ([]byte, error)
+}
+
+// Anonymizer replaces client IP addresses according to the configured privacy mode.
+type Anonymizer struct {
+	mode  string
+	salts SaltStore
+
+	mu   sync.Mutex
+	day  time.Time
+	salt []byte
+}
+
+// NewAnonymizer creates an Anonymizer for one of the config.PrivacyMode values.
+// salts is only used in config.PrivacyModeHash.
+func NewAnonymizer(mode string, salts SaltStore) *Anonymizer {
+	return &Anonymizer{mode: mode, salts: salts}
+}
+
+// Enabled reports whether IP addresses are anonymized.
+func (a *Anonymizer) Enabled() bool {
+	return a.mode == config.PrivacyModeTruncate || a.mode == config.PrivacyModeHash
+}
+
+// Anonymize returns the form of ip stored for a click at the given time. Hashes are
+// only comparable within a UTC day, which is what unique visitor counts rely on.
+//
+// If the salt of the day can't be loaded, the truncated address is returned with the error.
+func (a *Anonymizer) Anonymize(ctx context.Context, ip string, at time.Time) (string, error) {
+	switch a.mode {
+	case config.PrivacyModeTruncate:
+		return TruncateIP(ip), nil
+	case config.PrivacyModeHash:
+		salt, err := a.dailySalt(ctx, at)
+		if err != nil {
+			return TruncateIP(ip), err
+		}
+		return HashIP(ip, salt), nil
+	}
+	return ip, nil
+}
+
+// dailySalt returns the salt of the UTC day of at, caching the salt of the latest day seen.
+func (a *Anonymizer) dailySalt(ctx context.Context, at time.Time) ([]byte, error) {
+	day := at.UTC().Truncate(24 * time.Hour)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.salt != nil && a.day.Equal(day) {
+		return a.salt, nil
+	}
+
+	salt, err := a.salts.DailySalt(ctx, day)
+	if err != nil {
+		return nil, err
+	}
+	if day.After(a.day) {
+		a.day, a.salt = day, salt
+	}
+	return salt, nil
+}
+
+// TruncateIP zeroes the host part of an IP address, keeping the /24 network of IPv4
+// and the /48 network of IPv6 addresses. Unparsable addresses yield an empty string.
+func TruncateIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(ipv4PrefixBits, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(ipv6PrefixBits, 128)).String()
+}
+
+// HashIP returns a keyed hash of ip prefixed with HashedIPPrefix. Without the salt,
+// the hash can't be linked back to the address, even by enumerating all of them.
+func HashIP(ip string, salt []byte) string {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(ip))
+	return HashedIPPrefix + hex.EncodeToString(mac.Sum(nil)[:16])
+}
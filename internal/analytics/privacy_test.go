@@ -0,0 +1,125 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nccapo/url-sh/config"
+)
+
+func TestTruncateIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"203.0.113.42", "203.0.113.0"},
+		{"203.0.113.0", "203.0.113.0"},
+		{"::ffff:203.0.113.42", "203.0.113.0"},
+		{"2001:db8:abcd:1234::1", "2001:db8:abcd::"},
+		{"not an ip", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := TruncateIP(tt.ip); got != tt.want {
+			t.Errorf("TruncateIP(%q) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestHashIP(t *testing.T) {
+	salt := []byte("salt")
+	hash := HashIP("203.0.113.42", salt)
+
+	if !strings.HasPrefix(hash, HashedIPPrefix) || len(hash) != len(HashedIPPrefix)+32 {
+		t.Errorf("HashIP = %q, want %q followed by 32 hex digits", hash, HashedIPPrefix)
+	}
+	if again := HashIP("203.0.113.42", salt); again != hash {
+		t.Errorf("HashIP is not stable: %q then %q", hash, again)
+	}
+	if other := HashIP("203.0.113.43", salt); other == hash {
+		t.Error("different addresses have the same hash")
+	}
+	if resalted := HashIP("203.0.113.42", []byte("other salt")); resalted == hash {
+		t.Error("different salts give the same hash")
+	}
+}
+
+// memorySalts returns a distinct salt per day and counts the lookups.
+type memorySalts struct {
+	lookups int
+	err     error
+}
+
+func (m *memorySalts) DailySalt(_ context.Context, day time.Time) ([]byte, error) {
+	m.lookups++
+	if m.err != nil {
+		return nil, m.err
+	}
+	return []byte(day.Format(time.DateOnly)), nil
+}
+
+func TestAnonymizer(t *testing.T) {
+	const ip = "203.0.113.42"
+	day := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		mode    string
+		enabled bool
+		want    string
+	}{
+		{config.PrivacyModeOff, false, ip},
+		{config.PrivacyModeTruncate, true, "203.0.113.0"},
+		{config.PrivacyModeHash, true, HashIP(ip, []byte("2024-05-01"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			a := NewAnonymizer(tt.mode, &memorySalts{})
+			if a.Enabled() != tt.enabled {
+				t.Errorf("Enabled = %t, want %t", a.Enabled(), tt.enabled)
+			}
+			got, err := a.Anonymize(context.Background(), ip, day)
+			if err != nil {
+				t.Fatalf("Anonymize: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Anonymize = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnonymizerHashesPerUTCDay(t *testing.T) {
+	salts := &memorySalts{}
+	a := NewAnonymizer(config.PrivacyModeHash, salts)
+	ctx := context.Background()
+
+	morning, _ := a.Anonymize(ctx, "203.0.113.42", time.Date(2024, 5, 1, 1, 0, 0, 0, time.UTC))
+	evening, _ := a.Anonymize(ctx, "203.0.113.42", time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC))
+	if morning != evening {
+		t.Errorf("hashes of the same day differ: %q and %q", morning, evening)
+	}
+	if salts.lookups != 1 {
+		t.Errorf("salt of the day looked up %d times, want it cached", salts.lookups)
+	}
+
+	nextDay, _ := a.Anonymize(ctx, "203.0.113.42", time.Date(2024, 5, 2, 1, 0, 0, 0, time.UTC))
+	if nextDay == morning {
+		t.Error("hashes of different days are the same")
+	}
+}
+
+func TestAnonymizerTruncatesWithoutSalt(t *testing.T) {
+	failure := errors.New("database down")
+	a := NewAnonymizer(config.PrivacyModeHash, &memorySalts{err: failure})
+
+	got, err := a.Anonymize(context.Background(), "203.0.113.42", time.Now())
+	if !errors.Is(err, failure) {
+		t.Errorf("err = %v, want %v", err, failure)
+	}
+	if got != "203.0.113.0" {
+		t.Errorf("Anonymize = %q, want the truncated address", got)
+	}
+}
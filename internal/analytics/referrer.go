@@ -0,0 +1,44 @@
+// Package analytics enriches recorded clicks with data derived from the request.
+package analytics
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Referrer is a normalized Referer header.
+type Referrer struct {
+	// Domain is the lower-cased host without port and leading "www.".
+	Domain string
+	// URL is the domain followed by the path and, unless stripped, the query string.
+	URL string
+}
+
+// ParseReferrer normalizes a Referer header value to host and path, dropping the
+// scheme, credentials, port and fragment, and the query string if stripQuery is set.
+// Values that are empty or can't be parsed yield the zero Referrer, i.e. direct traffic.
+func ParseReferrer(raw string, stripQuery bool) Referrer {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return Referrer{}
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return Referrer{}
+	}
+
+	host := strings.ToLower(u.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimPrefix(host, "www.")
+
+	ref := host + strings.TrimSuffix(u.EscapedPath(), "/")
+	if !stripQuery && u.RawQuery != "" {
+		ref += "?" + u.RawQuery
+	}
+
+	return Referrer{Domain: host, URL: ref}
+}
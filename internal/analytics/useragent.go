@@ -0,0 +1,94 @@
+package analytics
+
+import (
+	"strings"
+
+	"github.com/mssola/useragent"
+)
+
+// Device types a user agent is classified as.
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+	// DeviceOther covers clients without a recognizable operating system, e.g. HTTP libraries.
+	DeviceOther = "other"
+)
+
+// osFamilies maps the operating system names reported by the parser to their common names.
+var osFamilies = map[string]string{
+	"iPhone OS": "iOS",
+	"OS":        "iOS", // iPads report "CPU OS x_y like Mac OS X"
+	"Mac OS X":  "macOS",
+}
+
+// UserAgent is a User-Agent header parsed into browser, operating system and device type.
+type UserAgent struct {
+	BrowserFamily  string
+	BrowserVersion string
+	OSFamily       string
+	OSVersion      string
+	DeviceType     string
+}
+
+// ParseUserAgent parses a User-Agent header. An empty header yields the zero UserAgent.
+func ParseUserAgent(raw string) UserAgent {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return UserAgent{}
+	}
+
+	ua := useragent.New(raw)
+
+	if ua.Bot() {
+		name, version := ua.Browser()
+		// The parser extracts URL fragments as browser name from crawler strings such as
+		// "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", keep the product token.
+		if name == "" || strings.ContainsAny(name, ":/") {
+			name, version = productToken(raw), ""
+		}
+		return UserAgent{BrowserFamily: name, BrowserVersion: version, DeviceType: DeviceBot}
+	}
+
+	var parsed UserAgent
+	parsed.BrowserFamily, parsed.BrowserVersion = ua.Browser()
+
+	os := ua.OSInfo()
+	parsed.OSFamily, parsed.OSVersion = os.Name, os.Version
+	if family, ok := osFamilies[os.Name]; ok {
+		parsed.OSFamily = family
+	}
+
+	switch {
+	case isTablet(ua, raw):
+		parsed.DeviceType = DeviceTablet
+	case ua.Mobile():
+		parsed.DeviceType = DeviceMobile
+	case parsed.OSFamily != "":
+		parsed.DeviceType = DeviceDesktop
+	default:
+		parsed.DeviceType = DeviceOther
+	}
+
+	return parsed
+}
+
+// isTablet reports whether the user agent belongs to a tablet. Android tablets are
+// told apart from phones by the absence of the "Mobile" token.
+func isTablet(ua *useragent.UserAgent, raw string) bool {
+	switch {
+	case ua.Platform() == "iPad", strings.Contains(raw, "Tablet"):
+		return true
+	case strings.Contains(raw, "Android"):
+		return !strings.Contains(raw, "Mobile")
+	}
+	return false
+}
+
+// productToken returns the leading product name of a user agent, e.g. "curl" for "curl/8.4.0".
+func productToken(raw string) string {
+	token, _, _ := strings.Cut(raw, " ")
+	token, _, _ = strings.Cut(token, "/")
+	return token
+}
@@ -0,0 +1,70 @@
+package analytics
+
+import "testing"
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want UserAgent
+	}{
+		{"empty", "", UserAgent{}},
+		{
+			"windows chrome",
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+			UserAgent{BrowserFamily: "Chrome", BrowserVersion: "124.0.0.0", OSFamily: "Windows", OSVersion: "10", DeviceType: DeviceDesktop},
+		},
+		{
+			"macos safari",
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15",
+			UserAgent{BrowserFamily: "Safari", BrowserVersion: "17.4", OSFamily: "macOS", OSVersion: "10.15.7", DeviceType: DeviceDesktop},
+		},
+		{
+			"iphone",
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+			UserAgent{BrowserFamily: "Safari", BrowserVersion: "17.4", OSFamily: "iOS", OSVersion: "17.4", DeviceType: DeviceMobile},
+		},
+		{
+			"ipad",
+			"Mozilla/5.0 (iPad; CPU OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+			UserAgent{BrowserFamily: "Safari", BrowserVersion: "17.4", OSFamily: "iOS", OSVersion: "17.4", DeviceType: DeviceTablet},
+		},
+		{
+			"android phone",
+			"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Mobile Safari/537.36",
+			UserAgent{BrowserFamily: "Chrome", BrowserVersion: "124.0.0.0", OSFamily: "Android", OSVersion: "14", DeviceType: DeviceMobile},
+		},
+		{
+			"android tablet",
+			"Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+			UserAgent{BrowserFamily: "Chrome", BrowserVersion: "124.0.0.0", OSFamily: "Android", OSVersion: "13", DeviceType: DeviceTablet},
+		},
+		{
+			"linux firefox",
+			"Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0",
+			UserAgent{BrowserFamily: "Firefox", BrowserVersion: "125.0", OSFamily: "Linux", DeviceType: DeviceDesktop},
+		},
+		{
+			"crawler",
+			"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			UserAgent{BrowserFamily: "Googlebot", BrowserVersion: "2.1", DeviceType: DeviceBot},
+		},
+		{
+			"crawler with a url as product",
+			"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)",
+			UserAgent{BrowserFamily: "Slackbot-LinkExpanding", DeviceType: DeviceBot},
+		},
+		{
+			"http library",
+			"curl/8.4.0",
+			UserAgent{BrowserFamily: "curl", BrowserVersion: "8.4.0", DeviceType: DeviceOther},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseUserAgent(tt.raw); got != tt.want {
+				t.Errorf("ParseUserAgent(%q) = %+v, want %+v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
@@ -4,14 +4,21 @@ package db
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"time"
 
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v5"
 	_ "github.com/jackc/pgx/v5/stdlib"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
+// undefinedTable is the Postgres error code raised when a relation does not exist.
+const undefinedTable = "42P01"
+
+// ErrNoMigrations is returned when no migration has been applied to the database yet.
+var ErrNoMigrations = errors.New("no migrations applied")
+
 // NewConn creates a new database connection with the given parameters.
 func NewConn(addr string, maxOpenConns, maxIdleConns int, maxIdleTime time.Duration) (*sql.DB, error) {
 	db, err := sql.Open("postgres", addr)
@@ -32,3 +39,18 @@ func NewConn(addr string, maxOpenConns, maxIdleConns int, maxIdleTime time.Durat
 
 	return db, nil
 }
+
+// MigrationVersion returns the current migration version recorded by golang-migrate
+// and whether the last migration left the schema dirty.
+func MigrationVersion(ctx context.Context, db *sql.DB) (version uint, dirty bool, err error) {
+	err = db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	var pqErr *pq.Error
+	if errors.Is(err, sql.ErrNoRows) || (errors.As(err, &pqErr) && pqErr.Code == undefinedTable) {
+		return 0, false, ErrNoMigrations
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	return version, dirty, nil
+}
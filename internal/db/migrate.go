@@ -0,0 +1,186 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// migrationLockID is the key of the Postgres advisory lock held while migrating,
+// so that replicas booting at the same time apply the migrations only once.
+const migrationLockID = 7261530044
+
+var (
+	// ErrSchemaDirty is returned when a previous migration failed half way.
+	ErrSchemaDirty = errors.New("schema is dirty")
+	// ErrSchemaAhead is returned when the database has migrations this binary doesn't know about.
+	ErrSchemaAhead = errors.New("schema is ahead of the binary")
+	// ErrSchemaBehind is returned when embedded migrations are still pending.
+	ErrSchemaBehind = errors.New("schema is behind the binary")
+)
+
+//go:embed migration/*.sql
+var migrations embed.FS
+
+// SchemaVersion is the migration version this binary expects the database to be at,
+// i.e. the version of the latest embedded migration.
+var SchemaVersion = latestVersion()
+
+// latestVersion returns the highest version prefix among the embedded migrations.
+func latestVersion() uint {
+	entries, err := fs.ReadDir(migrations, "migration")
+	if err != nil {
+		panic(err)
+	}
+
+	var latest uint
+	for _, entry := range entries {
+		prefix, _, _ := strings.Cut(entry.Name(), "_")
+		version, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			panic(fmt.Sprintf("invalid migration file name %q", entry.Name()))
+		}
+		latest = max(latest, uint(version))
+	}
+	return latest
+}
+
+// CheckSchema verifies the database schema can be served by this binary.
+// It returns ErrSchemaDirty, ErrSchemaAhead or ErrSchemaBehind wrapped with the versions.
+func CheckSchema(ctx context.Context, db *sql.DB) error {
+	version, dirty, err := MigrationVersion(ctx, db)
+	if errors.Is(err, ErrNoMigrations) {
+		return fmt.Errorf("%w: no migrations applied, expected version %d", ErrSchemaBehind, SchemaVersion)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case dirty:
+		return fmt.Errorf("%w: version %d failed to apply, fix it and force the version", ErrSchemaDirty, version)
+	case version > SchemaVersion:
+		return fmt.Errorf("%w: database is at version %d, binary expects %d", ErrSchemaAhead, version, SchemaVersion)
+	case version < SchemaVersion:
+		return fmt.Errorf("%w: database is at version %d, binary expects %d", ErrSchemaBehind, version, SchemaVersion)
+	}
+	return nil
+}
+
+// Migrator applies the embedded migrations to a database.
+type Migrator struct {
+	db *sql.DB
+	m  *migrate.Migrate
+}
+
+// NewMigrator creates a Migrator with its own connection pool to addr.
+// golang-migrate closes the pool it is given, so it is not shared with the application.
+func NewMigrator(addr string) (*Migrator, error) {
+	db, err := sql.Open("postgres", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := iofs.New(migrations, "migration")
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	driver, err := postgres.WithInstance(db, &postgres.Config{})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	m, err := migrate.NewWithInstance("iofs", source, "postgres", driver)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Migrator{db: db, m: m}, nil
+}
+
+// Close releases the migrator and its connection pool.
+func (m *Migrator) Close() error {
+	sourceErr, dbErr := m.m.Close()
+	return errors.Join(sourceErr, dbErr)
+}
+
+// Up applies all pending migrations, or only the next steps if steps > 0.
+// A dirty or ahead schema is refused rather than migrated.
+func (m *Migrator) Up(ctx context.Context, steps int) error {
+	return m.withLock(ctx, func() error {
+		if err := CheckSchema(ctx, m.db); err != nil && !errors.Is(err, ErrSchemaBehind) {
+			return err
+		}
+
+		var err error
+		if steps > 0 {
+			err = m.m.Steps(steps)
+		} else {
+			err = m.m.Up()
+		}
+		if errors.Is(err, migrate.ErrNoChange) {
+			return nil
+		}
+		return err
+	})
+}
+
+// Down rolls back the given number of migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	if steps <= 0 {
+		return fmt.Errorf("steps must be greater than 0, got %d", steps)
+	}
+
+	return m.withLock(ctx, func() error {
+		err := m.m.Steps(-steps)
+		if errors.Is(err, migrate.ErrNoChange) {
+			return nil
+		}
+		return err
+	})
+}
+
+// Force sets the recorded version without running any migration and clears the dirty flag.
+func (m *Migrator) Force(ctx context.Context, version int) error {
+	return m.withLock(ctx, func() error {
+		return m.m.Force(version)
+	})
+}
+
+// Status returns the recorded version and dirty flag.
+func (m *Migrator) Status(ctx context.Context) (version uint, dirty bool, err error) {
+	return MigrationVersion(ctx, m.db)
+}
+
+// withLock runs fn while holding the migration advisory lock on a dedicated connection.
+func (m *Migrator) withLock(ctx context.Context, fn func() error) (err error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return err
+	}
+	defer func() {
+		// The lock is session scoped, so it must be released on the same connection.
+		_, unlockErr := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+		err = errors.Join(err, unlockErr)
+	}()
+
+	return fn()
+}
@@ -0,0 +1,2 @@
+ALTER TABLE access_logs
+ALTER COLUMN short_url_id TYPE BIGINT;
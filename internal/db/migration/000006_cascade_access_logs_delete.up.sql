@@ -0,0 +1,3 @@
+ALTER TABLE access_logs
+DROP CONSTRAINT IF EXISTS access_logs_short_url_id_fkey,
+ADD CONSTRAINT access_logs_short_url_id_fkey FOREIGN KEY (short_url_id) REFERENCES short_urls (id) ON DELETE CASCADE;
@@ -0,0 +1 @@
+DROP INDEX CONCURRENTLY IF EXISTS access_logs_short_url_id_accessed_at_idx;
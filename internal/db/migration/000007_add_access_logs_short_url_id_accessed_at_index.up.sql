@@ -0,0 +1,2 @@
+-- Kept as the only statement of the file: CONCURRENTLY cannot run inside a transaction.
+CREATE INDEX CONCURRENTLY IF NOT EXISTS access_logs_short_url_id_accessed_at_idx ON access_logs (short_url_id, accessed_at DESC);
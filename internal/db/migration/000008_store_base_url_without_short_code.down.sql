@@ -0,0 +1,2 @@
+UPDATE short_urls
+SET base_url = rtrim(base_url, '/') || '/' || short_code;
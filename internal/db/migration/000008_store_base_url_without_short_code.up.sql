@@ -0,0 +1,4 @@
+-- base_url used to hold the full short URL, strip the trailing "/<short_code>".
+UPDATE short_urls
+SET base_url = left(base_url, length(base_url) - length(short_code) - 1)
+WHERE right(base_url, length(short_code) + 1) = '/' || short_code;
@@ -0,0 +1,18 @@
+ALTER TABLE short_urls
+ALTER COLUMN utm_source DROP NOT NULL,
+ALTER COLUMN utm_source DROP DEFAULT,
+ALTER COLUMN utm_medium DROP NOT NULL,
+ALTER COLUMN utm_medium DROP DEFAULT,
+ALTER COLUMN utm_campaign DROP NOT NULL,
+ALTER COLUMN utm_campaign DROP DEFAULT,
+ALTER COLUMN utm_term DROP NOT NULL,
+ALTER COLUMN utm_term DROP DEFAULT,
+ALTER COLUMN utm_content DROP NOT NULL,
+ALTER COLUMN utm_content DROP DEFAULT;
+
+ALTER TABLE access_logs
+ALTER COLUMN accessed_at TYPE TIMESTAMP USING accessed_at AT TIME ZONE 'UTC';
+
+ALTER TABLE short_urls
+ALTER COLUMN last_accessed TYPE TIMESTAMP USING last_accessed AT TIME ZONE 'UTC',
+ALTER COLUMN last_modified TYPE TIMESTAMP USING last_modified AT TIME ZONE 'UTC';
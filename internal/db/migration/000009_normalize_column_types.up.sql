@@ -0,0 +1,32 @@
+-- Timestamps without time zone were written by the service in UTC.
+ALTER TABLE short_urls
+ALTER COLUMN last_accessed TYPE TIMESTAMPTZ USING last_accessed AT TIME ZONE 'UTC',
+ALTER COLUMN last_modified TYPE TIMESTAMPTZ USING last_modified AT TIME ZONE 'UTC';
+
+ALTER TABLE access_logs
+ALTER COLUMN accessed_at TYPE TIMESTAMPTZ USING accessed_at AT TIME ZONE 'UTC';
+
+-- UTM parameters are scanned into strings and must never be NULL.
+UPDATE short_urls
+SET utm_source = COALESCE(utm_source, ''),
+    utm_medium = COALESCE(utm_medium, ''),
+    utm_campaign = COALESCE(utm_campaign, ''),
+    utm_term = COALESCE(utm_term, ''),
+    utm_content = COALESCE(utm_content, '')
+WHERE utm_source IS NULL
+   OR utm_medium IS NULL
+   OR utm_campaign IS NULL
+   OR utm_term IS NULL
+   OR utm_content IS NULL;
+
+ALTER TABLE short_urls
+ALTER COLUMN utm_source SET DEFAULT '',
+ALTER COLUMN utm_source SET NOT NULL,
+ALTER COLUMN utm_medium SET DEFAULT '',
+ALTER COLUMN utm_medium SET NOT NULL,
+ALTER COLUMN utm_campaign SET DEFAULT '',
+ALTER COLUMN utm_campaign SET NOT NULL,
+ALTER COLUMN utm_term SET DEFAULT '',
+ALTER COLUMN utm_term SET NOT NULL,
+ALTER COLUMN utm_content SET DEFAULT '',
+ALTER COLUMN utm_content SET NOT NULL;
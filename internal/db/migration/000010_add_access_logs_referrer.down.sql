@@ -0,0 +1,3 @@
+ALTER TABLE access_logs
+DROP COLUMN referrer,
+DROP COLUMN referrer_domain;
@@ -0,0 +1,3 @@
+ALTER TABLE access_logs
+ADD COLUMN referrer TEXT NOT NULL DEFAULT '',
+ADD COLUMN referrer_domain TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,6 @@
+ALTER TABLE access_logs
+DROP COLUMN browser_family,
+DROP COLUMN browser_version,
+DROP COLUMN os_family,
+DROP COLUMN os_version,
+DROP COLUMN device_type;
@@ -0,0 +1,6 @@
+ALTER TABLE access_logs
+ADD COLUMN browser_family TEXT NOT NULL DEFAULT '',
+ADD COLUMN browser_version TEXT NOT NULL DEFAULT '',
+ADD COLUMN os_family TEXT NOT NULL DEFAULT '',
+ADD COLUMN os_version TEXT NOT NULL DEFAULT '',
+ADD COLUMN device_type TEXT NOT NULL DEFAULT '';
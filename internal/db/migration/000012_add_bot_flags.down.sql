@@ -0,0 +1,5 @@
+ALTER TABLE short_urls
+DROP COLUMN bot_redirect_count;
+
+ALTER TABLE access_logs
+DROP COLUMN is_bot;
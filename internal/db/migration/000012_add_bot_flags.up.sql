@@ -0,0 +1,9 @@
+ALTER TABLE access_logs
+ADD COLUMN is_bot BOOLEAN NOT NULL DEFAULT false;
+
+UPDATE access_logs
+SET is_bot = true
+WHERE device_type = 'bot' OR user_agent = '';
+
+ALTER TABLE short_urls
+ADD COLUMN bot_redirect_count BIGINT NOT NULL DEFAULT 0;
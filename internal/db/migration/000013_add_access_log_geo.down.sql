@@ -0,0 +1,4 @@
+ALTER TABLE access_logs
+DROP COLUMN country_code,
+DROP COLUMN region_code,
+DROP COLUMN city;
@@ -0,0 +1,4 @@
+ALTER TABLE access_logs
+ADD COLUMN country_code TEXT NOT NULL DEFAULT '',
+ADD COLUMN region_code TEXT NOT NULL DEFAULT '',
+ADD COLUMN city TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,4 @@
+ALTER TABLE access_logs
+DROP COLUMN ip_anonymized;
+
+DROP TABLE IF EXISTS privacy_salts;
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS privacy_salts (
+    day DATE PRIMARY KEY,
+    salt BYTEA NOT NULL
+);
+
+ALTER TABLE access_logs
+ADD COLUMN ip_anonymized BOOLEAN NOT NULL DEFAULT false;
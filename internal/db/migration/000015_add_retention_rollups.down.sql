@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS daily_click_dimensions;
+
+DROP TABLE IF EXISTS daily_clicks;
+
+ALTER TABLE short_urls
+DROP COLUMN rolled_up_until,
+DROP COLUMN retention_days;
@@ -0,0 +1,25 @@
+-- NULL falls back to the global retention, 0 keeps the raw clicks of the link forever.
+ALTER TABLE short_urls
+ADD COLUMN retention_days INT CHECK (retention_days >= 0),
+-- Clicks of UTC days before rolled_up_until are served from the daily rollups,
+-- later ones from access_logs.
+ADD COLUMN rolled_up_until DATE;
+
+CREATE TABLE IF NOT EXISTS daily_clicks (
+    short_url_id BIGINT NOT NULL REFERENCES short_urls (id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    is_bot BOOLEAN NOT NULL,
+    clicks BIGINT NOT NULL,
+    unique_visitors BIGINT NOT NULL,
+    PRIMARY KEY (short_url_id, day, is_bot)
+);
+
+CREATE TABLE IF NOT EXISTS daily_click_dimensions (
+    short_url_id BIGINT NOT NULL REFERENCES short_urls (id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    is_bot BOOLEAN NOT NULL,
+    dimension TEXT NOT NULL,
+    value TEXT NOT NULL,
+    clicks BIGINT NOT NULL,
+    PRIMARY KEY (short_url_id, dimension, day, is_bot, value)
+);
@@ -0,0 +1,3 @@
+DELETE FROM daily_click_dimensions WHERE dimension = 'user_agent';
+
+DROP TABLE IF EXISTS daily_visitors;
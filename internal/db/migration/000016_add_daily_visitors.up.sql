@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS daily_visitors (
+    short_url_id BIGINT NOT NULL REFERENCES short_urls (id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    is_bot BOOLEAN NOT NULL,
+    ip_address TEXT NOT NULL,
+    PRIMARY KEY (short_url_id, day, is_bot, ip_address)
+);
+
+-- Backfill the visitors and user agents of the days already rolled up, as far as
+-- their access logs haven't been deleted yet.
+INSERT INTO daily_visitors (short_url_id, day, is_bot, ip_address)
+SELECT DISTINCT access_logs.short_url_id, (access_logs.accessed_at AT TIME ZONE 'UTC')::date, access_logs.is_bot, access_logs.ip_address
+FROM access_logs
+JOIN short_urls ON short_urls.id = access_logs.short_url_id
+WHERE access_logs.accessed_at < short_urls.rolled_up_until::timestamp AT TIME ZONE 'UTC'
+ON CONFLICT DO NOTHING;
+
+INSERT INTO daily_click_dimensions (short_url_id, day, is_bot, dimension, value, clicks)
+SELECT access_logs.short_url_id, (access_logs.accessed_at AT TIME ZONE 'UTC')::date, access_logs.is_bot, 'user_agent', access_logs.user_agent, COUNT(*)
+FROM access_logs
+JOIN short_urls ON short_urls.id = access_logs.short_url_id
+WHERE access_logs.accessed_at < short_urls.rolled_up_until::timestamp AT TIME ZONE 'UTC'
+GROUP BY 1, 2, 3, 4, 5
+ON CONFLICT DO NOTHING;
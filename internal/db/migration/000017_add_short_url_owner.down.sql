@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS short_urls_owner_id_idx;
+
+ALTER TABLE short_urls
+DROP COLUMN owner_id;
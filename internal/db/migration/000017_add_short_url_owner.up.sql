@@ -0,0 +1,5 @@
+-- Empty for short URLs created without a bearer token.
+ALTER TABLE short_urls
+ADD COLUMN owner_id TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS short_urls_owner_id_idx ON short_urls (owner_id) WHERE owner_id <> '';
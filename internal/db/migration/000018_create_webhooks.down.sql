@@ -0,0 +1,6 @@
+ALTER TABLE short_urls
+DROP COLUMN expiry_notified;
+
+DROP TABLE IF EXISTS webhook_deliveries;
+
+DROP TABLE IF EXISTS webhooks;
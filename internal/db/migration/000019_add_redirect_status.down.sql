@@ -0,0 +1,5 @@
+ALTER TABLE short_urls
+DROP COLUMN cache_redirects;
+
+ALTER TABLE short_urls
+DROP COLUMN redirect_status;
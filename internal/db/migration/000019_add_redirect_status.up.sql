@@ -0,0 +1,7 @@
+-- NULL uses the redirect status configured for the deployment.
+ALTER TABLE short_urls
+ADD COLUMN redirect_status SMALLINT CHECK (redirect_status IN (301, 302, 307, 308));
+
+-- Redirects are only cacheable when the owner opts in, cached redirects aren't counted.
+ALTER TABLE short_urls
+ADD COLUMN cache_redirects BOOLEAN NOT NULL DEFAULT false;
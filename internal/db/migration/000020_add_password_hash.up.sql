@@ -0,0 +1,3 @@
+-- bcrypt hash of the password required to follow the short URL, empty if unprotected.
+ALTER TABLE short_urls
+ADD COLUMN password_hash TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+-- NULL for short URLs that can be followed any number of times.
+ALTER TABLE short_urls
+ADD COLUMN max_clicks INTEGER CHECK (max_clicks > 0);
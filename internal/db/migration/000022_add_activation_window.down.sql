@@ -0,0 +1,8 @@
+ALTER TABLE short_urls
+DROP COLUMN ended_fallback_url,
+DROP COLUMN scheduled_fallback_url;
+
+ALTER TABLE short_urls
+DROP CONSTRAINT short_urls_activation_window_check,
+DROP COLUMN active_until,
+DROP COLUMN active_from;
@@ -0,0 +1,11 @@
+-- NULL bounds leave the activation window open on that side.
+ALTER TABLE short_urls
+ADD COLUMN active_from TIMESTAMPTZ,
+ADD COLUMN active_until TIMESTAMPTZ,
+ADD CONSTRAINT short_urls_activation_window_check CHECK (active_from < active_until);
+
+-- Where visitors are sent before and after the activation window, empty for the
+-- fallback configured for the deployment.
+ALTER TABLE short_urls
+ADD COLUMN scheduled_fallback_url TEXT NOT NULL DEFAULT '',
+ADD COLUMN ended_fallback_url TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+ALTER TABLE access_logs
+DROP COLUMN targeting_rule;
+
+ALTER TABLE short_urls
+DROP COLUMN targeting_rules;
@@ -0,0 +1,7 @@
+-- Ordered rules picking the destination of a click, see the targeting package.
+ALTER TABLE short_urls
+ADD COLUMN targeting_rules JSONB NOT NULL DEFAULT '[]';
+
+-- ID of the targeting rule the click was sent by, empty if it was sent to the original URL.
+ALTER TABLE access_logs
+ADD COLUMN targeting_rule TEXT NOT NULL DEFAULT '';
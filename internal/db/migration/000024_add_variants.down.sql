@@ -0,0 +1,6 @@
+ALTER TABLE access_logs
+DROP COLUMN variant;
+
+ALTER TABLE short_urls
+DROP COLUMN sticky_variants,
+DROP COLUMN variants;
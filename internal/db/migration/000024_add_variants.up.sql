@@ -0,0 +1,8 @@
+-- Weighted destinations the clicks matching no targeting rule are split between.
+ALTER TABLE short_urls
+ADD COLUMN variants JSONB NOT NULL DEFAULT '[]',
+ADD COLUMN sticky_variants BOOLEAN NOT NULL DEFAULT false;
+
+-- ID of the variant the click was sent to, empty if the short URL has none.
+ALTER TABLE access_logs
+ADD COLUMN variant TEXT NOT NULL DEFAULT '';
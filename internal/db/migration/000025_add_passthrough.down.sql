@@ -0,0 +1,4 @@
+ALTER TABLE short_urls
+DROP COLUMN query_precedence,
+DROP COLUMN forward_path,
+DROP COLUMN forward_query;
@@ -0,0 +1,5 @@
+-- Passthrough of the query string and sub-path of the short URL to the destination.
+ALTER TABLE short_urls
+ADD COLUMN forward_query BOOLEAN NOT NULL DEFAULT false,
+ADD COLUMN forward_path BOOLEAN NOT NULL DEFAULT false,
+ADD COLUMN query_precedence TEXT NOT NULL DEFAULT 'stored' CHECK (query_precedence IN ('stored', 'incoming'));
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS campaigns;
+
+ALTER TABLE short_urls
+DROP COLUMN tracking_params,
+DROP COLUMN utm_policy;
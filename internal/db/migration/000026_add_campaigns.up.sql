@@ -0,0 +1,21 @@
+-- Policy for the UTM parameters already set by the destination, empty for the
+-- policy of the deployment, and the extra tracking parameters added next to them.
+ALTER TABLE short_urls
+ADD COLUMN utm_policy TEXT NOT NULL DEFAULT '' CHECK (utm_policy IN ('', 'override', 'keep')),
+ADD COLUMN tracking_params JSONB NOT NULL DEFAULT '{}';
+
+-- Named sets of tracking parameters an owner applies to many short URLs.
+CREATE TABLE IF NOT EXISTS campaigns (
+    id BIGSERIAL PRIMARY KEY,
+    iid UUID NOT NULL UNIQUE DEFAULT gen_random_uuid (),
+    owner_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    utm_source TEXT NOT NULL DEFAULT '',
+    utm_medium TEXT NOT NULL DEFAULT '',
+    utm_campaign TEXT NOT NULL DEFAULT '',
+    utm_term TEXT NOT NULL DEFAULT '',
+    utm_content TEXT NOT NULL DEFAULT '',
+    tracking_params JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (owner_id, name)
+);
@@ -0,0 +1,6 @@
+ALTER TABLE short_urls
+DROP COLUMN created_at,
+DROP COLUMN og_image,
+DROP COLUMN og_description,
+DROP COLUMN og_title,
+DROP COLUMN preview;
@@ -0,0 +1,16 @@
+-- Preview page served in place of the redirect, and the Open Graph metadata shown
+-- on it to social unfurlers.
+ALTER TABLE short_urls
+ADD COLUMN preview BOOLEAN NOT NULL DEFAULT false,
+ADD COLUMN og_title TEXT NOT NULL DEFAULT '',
+ADD COLUMN og_description TEXT NOT NULL DEFAULT '',
+ADD COLUMN og_image TEXT NOT NULL DEFAULT '',
+ADD COLUMN created_at TIMESTAMPTZ;
+
+-- The creation date of existing short URLs is unknown, their earliest timestamp
+-- stands in for it.
+UPDATE short_urls SET created_at = COALESCE(LEAST(last_modified, last_accessed), NOW());
+
+ALTER TABLE short_urls
+ALTER COLUMN created_at SET DEFAULT NOW(),
+ALTER COLUMN created_at SET NOT NULL;
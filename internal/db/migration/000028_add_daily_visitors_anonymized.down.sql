@@ -0,0 +1,2 @@
+ALTER TABLE daily_visitors
+DROP COLUMN IF EXISTS ip_anonymized;
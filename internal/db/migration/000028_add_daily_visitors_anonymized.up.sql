@@ -0,0 +1,6 @@
+-- Rolled up visitors keep the IP addresses of their access logs, anonymized or not.
+ALTER TABLE daily_visitors
+ADD COLUMN IF NOT EXISTS ip_anonymized BOOLEAN NOT NULL DEFAULT false;
+
+UPDATE daily_visitors SET ip_anonymized = true
+WHERE ip_address LIKE 'h:%';
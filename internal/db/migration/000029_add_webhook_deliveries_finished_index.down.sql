@@ -0,0 +1 @@
+DROP INDEX CONCURRENTLY IF EXISTS webhook_deliveries_finished_idx;
@@ -0,0 +1,125 @@
+// Package events delivers the clicks recorded by the redirect handler to live subscribers.
+package events
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nccapo/url-sh/internal/metrics"
+)
+
+// Click is the event published for every recorded click.
+type Click struct {
+	ShortCode      string    `json:"short_code"`
+	OwnerID        string    `json:"-"`
+	AccessedAt     time.Time `json:"accessed_at"`
+	ReferrerDomain string    `json:"referrer_domain"`
+	BrowserFamily  string    `json:"browser_family"`
+	OSFamily       string    `json:"os_family"`
+	DeviceType     string    `json:"device_type"`
+	CountryCode    string    `json:"country_code"`
+	IsBot          bool      `json:"is_bot"`
+}
+
+// Publisher publishes click events.
+type Publisher interface {
+	Publish(ctx context.Context, click Click) error
+}
+
+// Filter selects the clicks a subscription receives.
+type Filter func(Click) bool
+
+// Subscription receives the clicks matching its filter.
+type Subscription struct {
+	// C delivers the matching clicks. It is closed when the broker is closed.
+	C <-chan Click
+
+	c       chan Click
+	filter  Filter
+	dropped atomic.Int64
+}
+
+// Dropped returns the number of clicks dropped because the subscriber fell behind
+// since the previous call, and resets it.
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Swap(0)
+}
+
+// Broker fans clicks out to the subscriptions of this process. Publishing never
+// blocks: a subscriber whose buffer is full misses the click, which is counted in
+// its Dropped.
+type Broker struct {
+	buffer int
+
+	mu     sync.RWMutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+// NewBroker creates a Broker buffering up to buffer clicks per subscriber.
+func NewBroker(buffer int) *Broker {
+	return &Broker{buffer: buffer, subs: map[*Subscription]struct{}{}}
+}
+
+// Publish delivers click to the matching subscriptions.
+func (b *Broker) Publish(_ context.Context, click Click) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subs {
+		if !sub.filter(click) {
+			continue
+		}
+		select {
+		case sub.c <- click:
+		default:
+			sub.dropped.Add(1)
+			metrics.StreamEventsDroppedTotal.Inc()
+		}
+	}
+	return nil
+}
+
+// Subscribe registers a subscription receiving the clicks matching filter.
+// It returns nil if the broker is closed.
+func (b *Broker) Subscribe(filter Filter) *Subscription {
+	c := make(chan Click, b.buffer)
+	sub := &Subscription{C: c, c: c, filter: filter}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	b.subs[sub] = struct{}{}
+	metrics.StreamSubscribers.Inc()
+	return sub
+}
+
+// Unsubscribe removes sub from the broker.
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		metrics.StreamSubscribers.Dec()
+	}
+}
+
+// Close closes every subscription, ending the streams served from them, and
+// rejects new ones. It is meant to be called on shutdown.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subs {
+		close(sub.c)
+		delete(b.subs, sub)
+		metrics.StreamSubscribers.Dec()
+	}
+}
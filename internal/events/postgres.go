@@ -0,0 +1,151 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/nccapo/url-sh/config"
+	"github.com/nccapo/url-sh/internal/metrics"
+)
+
+// notifyChannel is the Postgres channel clicks are relayed on.
+const notifyChannel = "url_sh_clicks"
+
+// publishQueue is the number of clicks waiting to be sent with NOTIFY. Clicks
+// published while it is full are dropped rather than holding up the redirects.
+const publishQueue = 1024
+
+// notifyTimeout bounds the NOTIFY of a single click.
+const notifyTimeout = 5 * time.Second
+
+// Reconnect backoff of the LISTEN connection.
+const (
+	listenMinReconnect = time.Second
+	listenMaxReconnect = time.Minute
+)
+
+// notification is the payload of a relayed click.
+type notification struct {
+	Click
+	OwnerID string `json:"owner_id"`
+}
+
+// PostgresRelay publishes clicks with NOTIFY and delivers the clicks published by
+// every replica, itself included, to a local Broker. Clicks published while the
+// LISTEN connection is down are not delivered.
+type PostgresRelay struct {
+	db       *sql.DB
+	broker   *Broker
+	listener *pq.Listener
+	done     chan struct{}
+
+	// queue holds the published clicks until they are sent by notify.
+	queue    chan Click
+	stop     chan struct{}
+	notified chan struct{}
+}
+
+// NewPostgresRelay starts listening for clicks on a dedicated connection to addr
+// and forwards them to broker. Clicks are published through db.
+func NewPostgresRelay(addr string, db *sql.DB, broker *Broker) (*PostgresRelay, error) {
+	listener := pq.NewListener(addr, listenMinReconnect, listenMaxReconnect, func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventDisconnected, pq.ListenerEventConnectionAttemptFailed:
+			config.Warn("Click stream listener disconnected: %v", err)
+		case pq.ListenerEventReconnected:
+			config.Info("Click stream listener reconnected")
+		}
+	})
+	if err := listener.Listen(notifyChannel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	r := &PostgresRelay{
+		db:       db,
+		broker:   broker,
+		listener: listener,
+		done:     make(chan struct{}),
+		queue:    make(chan Click, publishQueue),
+		stop:     make(chan struct{}),
+		notified: make(chan struct{}),
+	}
+	go r.forward()
+	go r.notify()
+	return r, nil
+}
+
+// Publish queues click to be sent to the listening replicas. It never blocks: the
+// click is dropped if too many are waiting to be sent.
+func (r *PostgresRelay) Publish(_ context.Context, click Click) error {
+	select {
+	case r.queue <- click:
+	default:
+		metrics.StreamRelayDroppedTotal.Inc()
+	}
+	return nil
+}
+
+// notify sends the queued clicks with NOTIFY until Close is called.
+func (r *PostgresRelay) notify() {
+	defer close(r.notified)
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case click := <-r.queue:
+			if err := r.send(click); err != nil {
+				config.Warn("Relaying click of %s: %v", click.ShortCode, err)
+			}
+		}
+	}
+}
+
+// send notifies the listening replicas of click.
+func (r *PostgresRelay) send(click Click) error {
+	payload, err := json.Marshal(notification{Click: click, OwnerID: click.OwnerID})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	_, err = r.db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, notifyChannel, string(payload))
+	return err
+}
+
+// forward delivers the received notifications to the broker until Close is called.
+func (r *PostgresRelay) forward() {
+	defer close(r.done)
+
+	for n := range r.listener.Notify {
+		// A nil notification signals a reconnection, nothing to deliver.
+		if n == nil {
+			continue
+		}
+
+		var received notification
+		if err := json.Unmarshal([]byte(n.Extra), &received); err != nil {
+			config.Warn("Invalid click notification: %v", err)
+			continue
+		}
+		received.Click.OwnerID = received.OwnerID
+		r.broker.Publish(context.Background(), received.Click)
+	}
+}
+
+// Close stops sending the queued clicks and listening.
+func (r *PostgresRelay) Close() error {
+	close(r.stop)
+	<-r.notified
+
+	err := r.listener.Close()
+	<-r.done
+	return err
+}
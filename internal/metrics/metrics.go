@@ -0,0 +1,128 @@
+// Package metrics provides Prometheus instrumentation for the URL shortener service.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "urlsh"
+
+// Redirect outcomes recorded by RedirectsTotal.
+const (
+	OutcomeHit      = "hit"
+	OutcomeNotFound = "not_found"
+	OutcomeExpired  = "expired"
+	// OutcomeLocked is recorded when the unlock page of a password-protected short URL is served.
+	OutcomeLocked = "locked"
+	// OutcomeExhausted is recorded when a short URL reached its click limit.
+	OutcomeExhausted = "exhausted"
+	// OutcomeScheduled and OutcomeEnded are recorded for visits before and after the
+	// activation window of a short URL.
+	OutcomeScheduled = "scheduled"
+	OutcomeEnded     = "ended"
+	// OutcomePreview is recorded when the preview page of a short URL is served.
+	OutcomePreview = "preview"
+	// OutcomeHead is recorded when a HEAD request is answered with the redirect headers.
+	OutcomeHead = "head"
+)
+
+// Registry holds every collector exposed on the metrics endpoint.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// RequestsTotal counts HTTP requests by route pattern, method and status code.
+	RequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Total number of HTTP requests by route pattern, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	// RequestDuration observes HTTP request latency by route pattern and method.
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route pattern and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	// RedirectsTotal counts short URL resolutions by outcome.
+	RedirectsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redirects_total",
+		Help:      "Total number of short URL redirects by outcome.",
+	}, []string{"outcome"})
+
+	// LinksCreatedTotal counts created short URLs by generation method.
+	LinksCreatedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "links_created_total",
+		Help:      "Total number of short URLs created by generation method.",
+	}, []string{"method"})
+
+	// CollisionRetriesTotal counts short code regenerations caused by collisions.
+	CollisionRetriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "collision_retries_total",
+		Help:      "Total number of short code regenerations caused by an existing code.",
+	}, []string{"method"})
+
+	// StreamSubscribers tracks the number of open click streams.
+	StreamSubscribers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "stream_subscribers",
+		Help:      "Number of open click event streams.",
+	})
+
+	// StreamEventsDroppedTotal counts click events not delivered to subscribers that fell behind.
+	StreamEventsDroppedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stream_events_dropped_total",
+		Help:      "Total number of click events dropped because a stream subscriber fell behind.",
+	})
+
+	// StreamRelayDroppedTotal counts click events not relayed to the other replicas
+	// because too many were waiting to be sent.
+	StreamRelayDroppedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stream_relay_dropped_total",
+		Help:      "Total number of click events dropped because the stream relay fell behind.",
+	})
+	// WebhookClicksDroppedTotal counts clicks not queued for webhooks because too many
+	// were waiting to be queued.
+	WebhookClicksDroppedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_clicks_dropped_total",
+		Help:      "Total number of clicks dropped because queueing their webhook deliveries fell behind.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		RequestsTotal,
+		RequestDuration,
+		RedirectsTotal,
+		LinksCreatedTotal,
+		CollisionRetriesTotal,
+		StreamSubscribers,
+		StreamEventsDroppedTotal,
+		StreamRelayDroppedTotal,
+		WebhookClicksDroppedTotal,
+	)
+}
+
+// RegisterDBStats exposes the sql.DBStats of the given connection pool.
+func RegisterDBStats(db *sql.DB) error {
+	return Registry.Register(collectors.NewDBStatsCollector(db, "postgres"))
+}
+
+// Handler returns the HTTP handler serving the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
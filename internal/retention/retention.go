@@ -0,0 +1,128 @@
+// Package retention prunes the raw clicks that are older than the retention of their
+// short URL, and the finished webhook deliveries.
+package retention
+
+import (
+	"context"
+	"time"
+
+	"github.com/nccapo/url-sh/config"
+	"github.com/nccapo/url-sh/internal/store"
+)
+
+// Job periodically rolls up or deletes expired access logs.
+type Job struct {
+	store *store.Store
+	cfg   *config.RetentionConfig
+}
+
+// NewJob creates a Job pruning the access logs of st according to cfg.
+func NewJob(st *store.Store, cfg *config.RetentionConfig) *Job {
+	return &Job{store: st, cfg: cfg}
+}
+
+// Run prunes once and then every cfg.Interval until ctx is done.
+func (j *Job) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := j.Prune(ctx); err != nil && ctx.Err() == nil {
+			config.Warn("Pruning access logs failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Prune rolls up or deletes the access logs older than the retention of their short URL,
+// and deletes the rolled up visitors of the same days in either mode, as well as the
+// webhook deliveries finished more than cfg.DeliveryDays ago. Rows are deleted in
+// batches of cfg.BatchSize, each in its own statement, so that no lock is held for long.
+func (j *Job) Prune(ctx context.Context) error {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	if err := j.pruneAccessLogs(ctx, today); err != nil {
+		return err
+	}
+
+	deleted, err := j.deleteInBatches(ctx, func() (int64, error) {
+		return j.store.Retention.DeleteExpiredVisitors(ctx, j.cfg.Days, today, j.cfg.BatchSize)
+	})
+	if deleted > 0 {
+		config.Info("Deleted %d expired rolled up visitors", deleted)
+	}
+	if err != nil {
+		return err
+	}
+
+	return j.pruneDeliveries(ctx)
+}
+
+// pruneDeliveries deletes the delivered and failed webhook deliveries queued more
+// than cfg.DeliveryDays ago.
+func (j *Job) pruneDeliveries(ctx context.Context) error {
+	if j.cfg.DeliveryDays == 0 {
+		return nil
+	}
+
+	before := time.Now().AddDate(0, 0, -j.cfg.DeliveryDays)
+	deleted, err := j.deleteInBatches(ctx, func() (int64, error) {
+		return j.store.Webhooks.DeleteFinished(ctx, before, j.cfg.BatchSize)
+	})
+	if deleted > 0 {
+		config.Info("Deleted %d finished webhook deliveries", deleted)
+	}
+	return err
+}
+
+// pruneAccessLogs rolls up or deletes the access logs older than the retention of their short URL.
+func (j *Job) pruneAccessLogs(ctx context.Context, today time.Time) error {
+	if j.cfg.Mode == config.RetentionModeDelete {
+		deleted, err := j.deleteInBatches(ctx, func() (int64, error) {
+			return j.store.Retention.DeleteExpired(ctx, j.cfg.Days, today, j.cfg.BatchSize)
+		})
+		if deleted > 0 {
+			config.Info("Deleted %d expired access logs", deleted)
+		}
+		return err
+	}
+
+	targets, err := j.store.Retention.DueRollups(ctx, j.cfg.Days, today)
+	if err != nil {
+		return err
+	}
+	for _, target := range targets {
+		if err := j.store.Retention.RollUp(ctx, target.ShortURLID, target.Until); err != nil {
+			return err
+		}
+	}
+
+	deleted, err := j.deleteInBatches(ctx, func() (int64, error) {
+		return j.store.Retention.DeleteRolledUp(ctx, j.cfg.Days, today, j.cfg.BatchSize)
+	})
+	if deleted > 0 {
+		config.Info("Deleted %d rolled up access logs", deleted)
+	}
+	return err
+}
+
+// deleteInBatches calls deleteBatch until it deletes less than a full batch, and
+// returns the total number of deleted rows.
+func (j *Job) deleteInBatches(ctx context.Context, deleteBatch func() (int64, error)) (int64, error) {
+	var total int64
+	for {
+		deleted, err := deleteBatch()
+		total += deleted
+		if err != nil || deleted < int64(j.cfg.BatchSize) {
+			return total, err
+		}
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Package rollup maintains the daily rollups the analytics queries read completed days from.
+package rollup
+
+import (
+	"context"
+	"time"
+
+	"github.com/nccapo/url-sh/config"
+	"github.com/nccapo/url-sh/internal/store"
+)
+
+// Job periodically rolls up the clicks of the UTC days that have ended.
+type Job struct {
+	store    *store.Store
+	interval time.Duration
+}
+
+// NewJob creates a Job rolling up the access logs of st every interval.
+func NewJob(st *store.Store, interval time.Duration) *Job {
+	return &Job{store: st, interval: interval}
+}
+
+// Run rolls up once and then every interval until ctx is done.
+func (j *Job) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := j.RollUp(ctx); err != nil && ctx.Err() == nil {
+			config.Warn("Rolling up access logs failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RollUp aggregates the clicks of every short URL recorded before today (UTC) that
+// aren't rolled up yet. Each short URL is rolled up in its own transaction, so that
+// an interrupted run resumes where it stopped.
+func (j *Job) RollUp(ctx context.Context) error {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	targets, err := j.store.Retention.PendingRollups(ctx, today)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range targets {
+		if err := j.store.Retention.RollUp(ctx, target.ShortURLID, target.Until); err != nil {
+			return err
+		}
+	}
+
+	if len(targets) > 0 {
+		config.Info("Rolled up the clicks of %d short URLs until %s", len(targets), today.Format(time.DateOnly))
+	}
+	return nil
+}
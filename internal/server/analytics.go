@@ -0,0 +1,294 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/nccapo/url-sh/internal/store"
+)
+
+// Default and maximum number of rows returned by the top-N reports.
+const (
+	defaultReportLimit = 10
+	maxReportLimit     = 100
+)
+
+// maxSeriesBuckets bounds the number of buckets a single time series request can produce.
+const maxSeriesBuckets = 5000
+
+// defaultSeriesBuckets is the number of buckets returned for each interval when from is omitted.
+var defaultSeriesBuckets = map[string]int{
+	store.IntervalMinute: 60,
+	store.IntervalHour:   24,
+	store.IntervalDay:    30,
+	store.IntervalWeek:   12,
+}
+
+// ClickSeries returns the clicks of a short URL bucketed by minute, hour, day or week.
+//
+// Query parameters: q (short code), interval (default day), from and to (RFC 3339,
+// default to now and a range sized to the interval), tz (IANA name, default UTC)
+// and include_bots (default false).
+func (h *Handler) ClickSeries(w http.ResponseWriter, r *http.Request) {
+	shortURL := r.URL.Query().Get("q")
+	if shortURL == "" {
+		http.Error(w, "shortURL is required", http.StatusBadRequest)
+		return
+	}
+
+	q, err := parseClickSeriesQuery(r.URL.Query(), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.Store.Shortener.FindWithShortCode(r.Context(), shortURL); errors.Is(err, store.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	uResp, err := h.Store.AccessLogs.ClickSeries(r.Context(), shortURL, q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Clicks interface{} `json:"clicks"`
+	}{
+		Clicks: uResp,
+	})
+}
+
+// TopReferrers returns the referrers that brought the most clicks to a short URL.
+//
+// Query parameters: q (short code), group (domain or url, default domain) and
+// limit (default 10). Clicks without referrer are reported as "(direct)".
+// Bot clicks are excluded unless include_bots is true.
+func (h *Handler) TopReferrers(w http.ResponseWriter, r *http.Request) {
+	shortURL := r.URL.Query().Get("q")
+	if shortURL == "" {
+		http.Error(w, "shortURL is required", http.StatusBadRequest)
+		return
+	}
+
+	groupBy := r.URL.Query().Get("group")
+	switch groupBy {
+	case "":
+		groupBy = store.ReferrerByDomain
+	case store.ReferrerByDomain, store.ReferrerByURL:
+	default:
+		http.Error(w, fmt.Sprintf("group must be domain or url, got %q", groupBy), http.StatusBadRequest)
+		return
+	}
+
+	limit, err := parseLimit(r.URL.Query().Get("limit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	includeBots, err := parseIncludeBots(r.URL.Query().Get("include_bots"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	uResp, err := h.Store.AccessLogs.TopReferrers(r.Context(), shortURL, groupBy, limit, includeBots)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		TopReferrers interface{} `json:"top_referrers"`
+	}{
+		TopReferrers: uResp,
+	})
+}
+
+// Breakdown returns the clicks of a short URL per browser, operating system, device type,
+// location, targeting rule or variant.
+//
+// Query parameters: q (short code), by (browser, browser_version, os, os_version,
+// device, country, region, city, rule or variant), limit (default 10) and
+// include_bots (default false).
+func (h *Handler) Breakdown(w http.ResponseWriter, r *http.Request) {
+	shortURL := r.URL.Query().Get("q")
+	if shortURL == "" {
+		http.Error(w, "shortURL is required", http.StatusBadRequest)
+		return
+	}
+
+	dimension := r.URL.Query().Get("by")
+	if !store.IsDimension(dimension) {
+		http.Error(w, fmt.Sprintf("unknown breakdown dimension %q", dimension), http.StatusBadRequest)
+		return
+	}
+
+	limit, err := parseLimit(r.URL.Query().Get("limit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	includeBots, err := parseIncludeBots(r.URL.Query().Get("include_bots"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	uResp, err := h.Store.AccessLogs.Breakdown(r.Context(), shortURL, dimension, limit, includeBots)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Dimension string      `json:"dimension"`
+		Breakdown interface{} `json:"breakdown"`
+	}{
+		Dimension: dimension,
+		Breakdown: uResp,
+	})
+}
+
+// GeoBreakdown returns the clicks of a short URL per country, region or city.
+//
+// Query parameters: q (short code), level (country, region or city, default country),
+// limit (default 10) and include_bots (default false). Clicks that couldn't be
+// located, e.g. because no GeoIP database is configured, are reported as "(unknown)".
+func (h *Handler) GeoBreakdown(w http.ResponseWriter, r *http.Request) {
+	shortURL := r.URL.Query().Get("q")
+	if shortURL == "" {
+		http.Error(w, "shortURL is required", http.StatusBadRequest)
+		return
+	}
+
+	level := r.URL.Query().Get("level")
+	switch level {
+	case "":
+		level = store.DimensionCountry
+	case store.DimensionCountry, store.DimensionRegion, store.DimensionCity:
+	default:
+		http.Error(w, fmt.Sprintf("level must be country, region or city, got %q", level), http.StatusBadRequest)
+		return
+	}
+
+	limit, err := parseLimit(r.URL.Query().Get("limit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	includeBots, err := parseIncludeBots(r.URL.Query().Get("include_bots"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	uResp, err := h.Store.AccessLogs.Breakdown(r.Context(), shortURL, level, limit, includeBots)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Level     string      `json:"level"`
+		Locations interface{} `json:"locations"`
+	}{
+		Level:     level,
+		Locations: uResp,
+	})
+}
+
+// parseLimit parses the limit query parameter of the top-N reports.
+func parseLimit(value string) (int, error) {
+	if value == "" {
+		return defaultReportLimit, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 || limit > maxReportLimit {
+		return 0, fmt.Errorf("limit must be between 1 and %d", maxReportLimit)
+	}
+	return limit, nil
+}
+
+// parseIncludeBots parses the include_bots query parameter of the analytics
+// endpoints. Bot clicks are excluded when it is omitted.
+func parseIncludeBots(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+
+	include, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("include_bots must be true or false, got %q", value)
+	}
+	return include, nil
+}
+
+// parseClickSeriesQuery validates the time series parameters and fills in their defaults.
+func parseClickSeriesQuery(values url.Values, now time.Time) (store.ClickSeriesQuery, error) {
+	q := store.ClickSeriesQuery{
+		Interval: values.Get("interval"),
+		Location: time.UTC,
+	}
+	if q.Interval == "" {
+		q.Interval = store.IntervalDay
+	}
+
+	includeBots, err := parseIncludeBots(values.Get("include_bots"))
+	if err != nil {
+		return q, err
+	}
+	q.IncludeBots = includeBots
+
+	step, ok := store.IntervalDuration(q.Interval)
+	if !ok {
+		return q, fmt.Errorf("interval must be one of minute, hour, day or week, got %q", q.Interval)
+	}
+
+	if tz := values.Get("tz"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return q, fmt.Errorf("unknown time zone %q", tz)
+		}
+		q.Location = loc
+	}
+
+	q.To = now
+	if to := values.Get("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return q, fmt.Errorf("to must be an RFC 3339 timestamp: %w", err)
+		}
+		q.To = t
+	}
+
+	q.From = q.To.Add(-time.Duration(defaultSeriesBuckets[q.Interval]) * step)
+	if from := values.Get("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return q, fmt.Errorf("from must be an RFC 3339 timestamp: %w", err)
+		}
+		q.From = t
+	}
+
+	if !q.From.Before(q.To) {
+		return q, errors.New("from must be before to")
+	}
+	if q.To.Sub(q.From)/step > maxSeriesBuckets {
+		return q, fmt.Errorf("range spans more than %d %s buckets, use a larger interval", maxSeriesBuckets, q.Interval)
+	}
+
+	return q, nil
+}
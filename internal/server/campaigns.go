@@ -0,0 +1,217 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/nccapo/url-sh/internal/store"
+)
+
+const (
+	// maxTrackingParams bounds the tracking parameters of a short URL or campaign.
+	maxTrackingParams = 20
+	// maxCampaignName bounds the length of campaign names.
+	maxCampaignName = 64
+)
+
+// CampaignRequest creates a named set of UTM and tracking parameters for the short
+// URLs of the owner of the request.
+type CampaignRequest struct {
+	// Name identifies the campaign among those of the owner, it is used in paths.
+	Name string `json:"name"`
+	// UTM Parameters
+	UTMSource      string               `json:"utm_source"`
+	UTMMedium      string               `json:"utm_medium"`
+	UTMCampaign    string               `json:"utm_campaign"`
+	UTMTerm        string               `json:"utm_term"`
+	UTMContent     string               `json:"utm_content"`
+	TrackingParams store.TrackingParams `json:"tracking_params"`
+}
+
+func (h *Handler) CreateCampaign(w http.ResponseWriter, r *http.Request) {
+	owner, ok := h.requireOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var req CampaignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateCampaignName(req.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateTrackingParams(req.TrackingParams); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	campaign, err := h.Store.Campaigns.Create(r.Context(), &store.Campaign{
+		OwnerID:        owner,
+		Name:           req.Name,
+		UTMSource:      req.UTMSource,
+		UTMMedium:      req.UTMMedium,
+		UTMCampaign:    req.UTMCampaign,
+		UTMTerm:        req.UTMTerm,
+		UTMContent:     req.UTMContent,
+		TrackingParams: req.TrackingParams,
+	})
+	if errors.Is(err, store.ErrDuplicateCampaign) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, struct {
+		Campaign interface{} `json:"campaign"`
+	}{
+		Campaign: campaign,
+	})
+}
+
+func (h *Handler) ListCampaigns(w http.ResponseWriter, r *http.Request) {
+	owner, ok := h.requireOwner(w, r)
+	if !ok {
+		return
+	}
+
+	campaigns, err := h.Store.Campaigns.List(r.Context(), owner)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Campaigns interface{} `json:"campaigns"`
+	}{
+		Campaigns: campaigns,
+	})
+}
+
+func (h *Handler) GetCampaign(w http.ResponseWriter, r *http.Request) {
+	owner, ok := h.requireOwner(w, r)
+	if !ok {
+		return
+	}
+
+	campaign, err := h.Store.Campaigns.Find(r.Context(), owner, r.PathValue("name"))
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Campaign interface{} `json:"campaign"`
+	}{
+		Campaign: campaign,
+	})
+}
+
+func (h *Handler) DeleteCampaign(w http.ResponseWriter, r *http.Request) {
+	owner, ok := h.requireOwner(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.Store.Campaigns.Delete(r.Context(), owner, r.PathValue("name"))
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// campaign finds the campaign a short URL is created or updated with among those of
+// owner. Unknown campaigns are a bad request rather than a missing resource.
+func (h *Handler) campaign(w http.ResponseWriter, r *http.Request, owner, name string) (*store.Campaign, bool) {
+	campaign, err := h.Store.Campaigns.Find(r.Context(), owner, name)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, fmt.Sprintf("unknown campaign %q", name), http.StatusBadRequest)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return campaign, true
+}
+
+// applyCampaign copies the parameters set by campaign into link.
+func applyCampaign(link *store.URLShortener, campaign *store.Campaign) {
+	for _, field := range []struct {
+		dst *string
+		src string
+	}{
+		{&link.UTMSource, campaign.UTMSource},
+		{&link.UTMMedium, campaign.UTMMedium},
+		{&link.UTMCampaign, campaign.UTMCampaign},
+		{&link.UTMTerm, campaign.UTMTerm},
+		{&link.UTMContent, campaign.UTMContent},
+	} {
+		if field.src != "" {
+			*field.dst = field.src
+		}
+	}
+
+	if link.TrackingParams == nil {
+		link.TrackingParams = store.TrackingParams{}
+	}
+	for key, value := range campaign.TrackingParams {
+		link.TrackingParams[key] = value
+	}
+}
+
+func validateCampaignName(name string) error {
+	if name == "" || len(name) > maxCampaignName {
+		return fmt.Errorf("name must be between 1 and %d characters", maxCampaignName)
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return errors.New("name may only contain letters, digits, '-', '_' and '.'")
+		}
+	}
+	return nil
+}
+
+// validateTrackingParams checks the tracking parameters of a short URL or campaign.
+// UTM parameters have their own fields.
+func validateTrackingParams(params store.TrackingParams) error {
+	if len(params) > maxTrackingParams {
+		return fmt.Errorf("at most %d tracking_params are allowed", maxTrackingParams)
+	}
+	for key := range params {
+		if key == "" {
+			return errors.New("tracking_params keys must not be empty")
+		}
+		if strings.HasPrefix(strings.ToLower(key), "utm_") {
+			return fmt.Errorf("tracking param %q is a UTM parameter, set it with its own field", key)
+		}
+	}
+	return nil
+}
+
+func validateUTMPolicy(policy string) error {
+	switch policy {
+	case "", store.UTMPolicyOverride, store.UTMPolicyKeep:
+		return nil
+	}
+	return fmt.Errorf("utm_policy must be override or keep, got %q", policy)
+}
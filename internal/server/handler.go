@@ -2,20 +2,51 @@
 package server
 
 import (
+	"cmp"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
-	"net/url"
 	"strings"
 	"time"
 
+	"github.com/nccapo/url-sh/config"
+	"github.com/nccapo/url-sh/internal/analytics"
+	"github.com/nccapo/url-sh/internal/events"
 	"github.com/nccapo/url-sh/internal/gen"
+	"github.com/nccapo/url-sh/internal/metrics"
 	"github.com/nccapo/url-sh/internal/store"
+	"github.com/nccapo/url-sh/internal/targeting"
+	"github.com/nccapo/url-sh/internal/webhooks"
 )
 
 var H Handler
 
+// maxCollisionRetries is the number of times a generated short code is regenerated
+// when it collides with an existing one.
+const maxCollisionRetries = 3
+
 type Handler struct {
-	Store *store.Store `json:"store"`
+	Store  *store.Store   `json:"store"`
+	Config *config.Config `json:"-"`
+	// DB is the connection pool probed by the readiness endpoint.
+	DB *sql.DB `json:"-"`
+	// Checks are additional readiness checks, run after the built-in ones.
+	Checks []ReadinessCheck `json:"-"`
+	// GeoIP geolocates recorded clicks, nil if no database is configured.
+	GeoIP *analytics.GeoIP `json:"-"`
+	// Anonymizer replaces client IP addresses before they are recorded.
+	Anonymizer *analytics.Anonymizer `json:"-"`
+	// Broker delivers recorded clicks to the open click streams.
+	Broker *events.Broker `json:"-"`
+	// Clicks publishes recorded clicks to the streams of every replica.
+	Clicks events.Publisher `json:"-"`
+	// Webhooks queues the events of short URLs for the webhooks of their owner.
+	Webhooks *webhooks.Notifier `json:"-"`
+
+	// unlockAttempts throttles wrong passwords per short code.
+	unlockAttempts *attemptLimiter
 }
 
 type URLRequest struct {
@@ -28,6 +59,53 @@ type URLRequest struct {
 	UTMCampaign string `json:"utm_campaign,omitempty"`
 	UTMTerm     string `json:"utm_term,omitempty"`
 	UTMContent  string `json:"utm_content,omitempty"`
+	// RetentionDays overrides the global retention of raw clicks, 0 keeps them forever.
+	RetentionDays *int `json:"retention_days,omitempty"`
+	// RedirectStatus overrides the redirect status of the deployment: 301, 302, 307 or 308.
+	RedirectStatus *int `json:"redirect_status,omitempty"`
+	// CacheRedirects lets browsers and proxies cache the redirect, the clicks they
+	// serve from their cache aren't counted.
+	CacheRedirects bool `json:"cache_redirects,omitempty"`
+	// Password protects the short URL behind an unlock page.
+	Password string `json:"password,omitempty"`
+	// MaxClicks is the number of times the short URL can be followed, 0 for unlimited.
+	// It defaults to the limit configured for the deployment.
+	MaxClicks *int `json:"max_clicks,omitempty"`
+	// OneTime limits the short URL to a single click.
+	OneTime bool `json:"one_time,omitempty"`
+	// ActiveFrom and ActiveUntil bound the window the short URL can be followed in.
+	ActiveFrom  *time.Time `json:"active_from,omitempty"`
+	ActiveUntil *time.Time `json:"active_until,omitempty"`
+	// ScheduledFallbackURL and EndedFallbackURL are where visitors are sent before
+	// and after the activation window.
+	ScheduledFallbackURL string `json:"scheduled_fallback_url,omitempty"`
+	EndedFallbackURL     string `json:"ended_fallback_url,omitempty"`
+	// TargetingRules send the matching clicks elsewhere than URL, the first match wins.
+	TargetingRules targeting.Rules `json:"targeting_rules,omitempty"`
+	// Variants split the clicks matching no targeting rule between weighted destinations.
+	Variants targeting.Variants `json:"variants,omitempty"`
+	// StickyVariants keeps serving a visitor the variant they were first served.
+	StickyVariants bool `json:"sticky_variants,omitempty"`
+	// ForwardQuery merges the query string of the short URL into the destination.
+	ForwardQuery bool `json:"forward_query,omitempty"`
+	// ForwardPath appends the sub-path of the short URL to the destination.
+	ForwardPath bool `json:"forward_path,omitempty"`
+	// QueryPrecedence is stored or incoming, stored by default.
+	QueryPrecedence string `json:"query_precedence,omitempty"`
+	// UTMPolicy is override or keep, the policy of the deployment by default.
+	UTMPolicy string `json:"utm_policy,omitempty"`
+	// TrackingParams are added to the destination next to the UTM parameters.
+	TrackingParams store.TrackingParams `json:"tracking_params,omitempty"`
+	// Campaign is the name of a campaign of the owner whose parameters are applied
+	// to the short URL. The parameters set in the request take precedence.
+	Campaign string `json:"campaign,omitempty"`
+	// Preview serves a preview page in place of the redirect.
+	Preview bool `json:"preview,omitempty"`
+	// OGTitle, OGDescription and OGImage are the Open Graph metadata of the preview
+	// page, served to social unfurlers in place of the destination's.
+	OGTitle       string `json:"og_title,omitempty"`
+	OGDescription string `json:"og_description,omitempty"`
+	OGImage       string `json:"og_image,omitempty"`
 }
 
 func (h *Handler) ShortenURL(w http.ResponseWriter, r *http.Request) {
@@ -39,35 +117,166 @@ func (h *Handler) ShortenURL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s := gen.NewShortener("http://localhost:8090")
-	// Initialize the shortener with the provided method
-	s.Method = req.Method
-	s.OriginalURL = req.URL
-	err := s.GenerateShortURL(req.Alias)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if req.Method == gen.Custom && (isReservedCode(req.Alias) || strings.HasSuffix(req.Alias, previewSuffix)) {
+		http.Error(w, fmt.Sprintf("alias %q is reserved", req.Alias), http.StatusBadRequest)
 		return
 	}
 
-	uResp, err := h.Store.Shortener.Create(r.Context(), &store.URLShortener{
-		ShortCode:     s.ShortCode,
-		OriginalURL:   req.URL,
-		Method:        string(req.Method),
-		BaseURL:       "http://localhost:8090",
-		RedirectCount: 0,
-		LastAccessed:  time.Now(),
-		LastModified:  time.Now(),
-		UTMSource:     req.UTMSource,
-		UTMMedium:     req.UTMMedium,
-		UTMCampaign:   req.UTMCampaign,
-		UTMTerm:       req.UTMTerm,
-		UTMContent:    req.UTMContent,
-	})
+	if req.RetentionDays != nil && *req.RetentionDays < 0 {
+		http.Error(w, "retention_days must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	if req.RedirectStatus != nil && !isRedirectStatus(*req.RedirectStatus) {
+		http.Error(w, fmt.Sprintf("redirect_status must be one of 301, 302, 307 or 308, got %d", *req.RedirectStatus), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateSchedule(req.ActiveFrom, req.ActiveUntil, req.ScheduledFallbackURL, req.EndedFallbackURL); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.TargetingRules.Normalize(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Variants.Normalize(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.QueryPrecedence == "" {
+		req.QueryPrecedence = store.QueryPrecedenceStored
+	}
+	if err := validateQueryPrecedence(req.QueryPrecedence); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateUTMPolicy(req.UTMPolicy); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateTrackingParams(req.TrackingParams); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateOpenGraph(req.OGTitle, req.OGDescription, req.OGImage); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Campaign != "" {
+		owner, ok := h.requireOwner(w, r)
+		if !ok {
+			return
+		}
+		campaign, ok := h.campaign(w, r, owner, req.Campaign)
+		if !ok {
+			return
+		}
+
+		defaults := &store.URLShortener{}
+		applyCampaign(defaults, campaign)
+		req.UTMSource = cmp.Or(req.UTMSource, defaults.UTMSource)
+		req.UTMMedium = cmp.Or(req.UTMMedium, defaults.UTMMedium)
+		req.UTMCampaign = cmp.Or(req.UTMCampaign, defaults.UTMCampaign)
+		req.UTMTerm = cmp.Or(req.UTMTerm, defaults.UTMTerm)
+		req.UTMContent = cmp.Or(req.UTMContent, defaults.UTMContent)
+		for key, value := range req.TrackingParams {
+			defaults.TrackingParams[key] = value
+		}
+		req.TrackingParams = defaults.TrackingParams
+	}
+
+	maxClicks, err := h.maxClicks(&req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	var passwordHash string
+	if req.Password != "" {
+		hash, err := hashPassword(req.Password)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		passwordHash = hash
+	}
+
+	s := gen.NewShortener("http://localhost:8090")
+	// Initialize the shortener with the provided method
+	s.Method = req.Method
+	s.OriginalURL = req.URL
+
+	var uResp *store.URLShortener
+	for attempt := 0; ; attempt++ {
+		err := s.GenerateShortURL(req.Alias)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		uResp, err = h.Store.Shortener.Create(r.Context(), &store.URLShortener{
+			ShortCode:            s.ShortCode,
+			OriginalURL:          req.URL,
+			Method:               string(req.Method),
+			BaseURL:              "http://localhost:8090",
+			RedirectCount:        0,
+			LastAccessed:         time.Now(),
+			LastModified:         time.Now(),
+			UTMSource:            req.UTMSource,
+			UTMMedium:            req.UTMMedium,
+			UTMCampaign:          req.UTMCampaign,
+			UTMTerm:              req.UTMTerm,
+			UTMContent:           req.UTMContent,
+			RetentionDays:        req.RetentionDays,
+			OwnerID:              h.ownerID(r),
+			RedirectStatus:       req.RedirectStatus,
+			CacheRedirects:       req.CacheRedirects,
+			PasswordHash:         passwordHash,
+			MaxClicks:            maxClicks,
+			ActiveFrom:           req.ActiveFrom,
+			ActiveUntil:          req.ActiveUntil,
+			ScheduledFallbackURL: req.ScheduledFallbackURL,
+			EndedFallbackURL:     req.EndedFallbackURL,
+			TargetingRules:       req.TargetingRules,
+			Variants:             req.Variants,
+			StickyVariants:       req.StickyVariants,
+			ForwardQuery:         req.ForwardQuery,
+			ForwardPath:          req.ForwardPath,
+			QueryPrecedence:      req.QueryPrecedence,
+			UTMPolicy:            req.UTMPolicy,
+			TrackingParams:       req.TrackingParams,
+			Preview:              req.Preview,
+			OGTitle:              req.OGTitle,
+			OGDescription:        req.OGDescription,
+			OGImage:              req.OGImage,
+		})
+		if errors.Is(err, store.ErrDuplicateShortCode) {
+			// Only randomly generated codes can succeed on a second attempt.
+			if (s.Method == gen.Random || s.Method == gen.Secure) && attempt < maxCollisionRetries {
+				metrics.CollisionRetriesTotal.WithLabelValues(string(s.Method)).Inc()
+				continue
+			}
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		break
+	}
+
+	metrics.LinksCreatedTotal.WithLabelValues(string(s.Method)).Inc()
+
+	h.notifyLink(r, webhooks.EventLinkCreated, uResp)
+
 	// Create response struct
 	response := struct {
 		Shortener interface{} `json:"shortener"`
@@ -94,6 +303,10 @@ func (h *Handler) GetURLStats(w http.ResponseWriter, r *http.Request) {
 	}
 
 	uResp, err := h.Store.Shortener.FindWithShortCode(r.Context(), code)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -103,7 +316,7 @@ func (h *Handler) GetURLStats(w http.ResponseWriter, r *http.Request) {
 	response := struct {
 		Shortener interface{} `json:"shortener"`
 	}{
-		Shortener: uResp,
+		Shortener: h.withoutDestinations(r, uResp),
 	}
 
 	// Set content type header
@@ -117,6 +330,9 @@ func (h *Handler) GetURLStats(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// UpdateVisitsCount redirects a short URL to its destination. GET requests are
+// counted and logged as clicks, HEAD requests get the same redirect headers
+// without leaving a trace.
 func (h *Handler) UpdateVisitsCount(w http.ResponseWriter, r *http.Request) {
 	// Get IP address
 	ipAddress := getIPAddress(r)
@@ -124,64 +340,194 @@ func (h *Handler) UpdateVisitsCount(w http.ResponseWriter, r *http.Request) {
 	// Get User Agent
 	userAgent := r.Header.Get("User-Agent")
 
-	code := r.PathValue("code")
+	// Parse User Agent into browser, OS and device type
+	agent := analytics.ParseUserAgent(userAgent)
+
+	// Classify automated clicks, their device type is reported as bot
+	isBot := analytics.IsBot(r, agent)
+	if isBot {
+		agent.DeviceType = analytics.DeviceBot
+	}
+
+	// Resolve the geographic location of the IP address
+	location := h.GeoIP.Lookup(ipAddress)
+
+	// Get Referrer
+	referrer := analytics.ParseReferrer(r.Referer(), h.Config.AnalyticsConfig.StripReferrerQuery)
+
+	code, preview := strings.CutSuffix(r.PathValue("code"), previewSuffix)
 	if code == "" {
 		http.Error(w, "code is required", http.StatusBadRequest)
 		return
 	}
 
 	uResp, err := h.Store.Shortener.FindWithShortCode(r.Context(), code)
+	if errors.Is(err, store.ErrNotFound) {
+		metrics.RedirectsTotal.WithLabelValues(metrics.OutcomeNotFound).Inc()
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err = h.Store.AccessLogs.CreateLog(r.Context(), &store.AccessLog{
-		IPAddress:  ipAddress,
-		UserAgent:  userAgent,
-		ShortURLID: int64(uResp.ID),
-		AccessedAt: time.Now(),
-	})
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// Sub-paths are only served by wildcard short URLs
+	if r.PathValue("rest") != "" && !uResp.ForwardPath {
+		metrics.RedirectsTotal.WithLabelValues(metrics.OutcomeNotFound).Inc()
+		http.Error(w, "short URL doesn't forward sub-paths", http.StatusNotFound)
 		return
 	}
 
-	err = h.Store.Shortener.UpdateRedirectCount(r.Context(), uResp.ID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	now := time.Now()
+	if uResp.IsExpired(now) {
+		metrics.RedirectsTotal.WithLabelValues(metrics.OutcomeExpired).Inc()
+		http.Error(w, "short URL has expired", http.StatusGone)
 		return
 	}
 
-	// Build the redirect URL with UTM parameters if they exist
-	redirectURL := uResp.OriginalURL
-	if uResp.UTMSource != "" || uResp.UTMMedium != "" || uResp.UTMCampaign != "" || uResp.UTMTerm != "" || uResp.UTMContent != "" {
-		params := url.Values{}
-		if uResp.UTMSource != "" {
-			params.Add("utm_source", uResp.UTMSource)
-		}
-		if uResp.UTMMedium != "" {
-			params.Add("utm_medium", uResp.UTMMedium)
-		}
-		if uResp.UTMCampaign != "" {
-			params.Add("utm_campaign", uResp.UTMCampaign)
+	// Short URLs are only followed within their activation window
+	if state := uResp.State(now); state != store.LinkStateActive {
+		h.serveInactive(w, r, uResp, state, now)
+		return
+	}
+
+	if uResp.IsExhausted() {
+		metrics.RedirectsTotal.WithLabelValues(metrics.OutcomeExhausted).Inc()
+		http.Error(w, "short URL has reached its click limit", http.StatusGone)
+		return
+	}
+
+	// Previews, and the Open Graph metadata served to unfurlers, aren't clicks
+	if wantsPreview(r, uResp, preview, isBot) {
+		h.servePreview(w, r, uResp)
+		return
+	}
+
+	// Password-protected short URLs are only followed, and counted, once unlocked
+	if !h.requireUnlocked(w, r, uResp) {
+		return
+	}
+
+	// Only GET requests are clicks, HEAD requests are answered with the headers of
+	// the redirect without being counted
+	counted := r.Method == http.MethodGet
+
+	// Count the click first, it fails once the click limit is reached
+	var count int
+	if counted {
+		count, err = h.Store.Shortener.UpdateRedirectCount(r.Context(), uResp.ID, isBot)
+		if errors.Is(err, store.ErrClickLimitReached) {
+			metrics.RedirectsTotal.WithLabelValues(metrics.OutcomeExhausted).Inc()
+			http.Error(w, "short URL has reached its click limit", http.StatusGone)
+			return
 		}
-		if uResp.UTMTerm != "" {
-			params.Add("utm_term", uResp.UTMTerm)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		if uResp.UTMContent != "" {
-			params.Add("utm_content", uResp.UTMContent)
+	}
+
+	// Pick the destination of the first matching targeting rule, or else of a variant
+	target, ruleID, variantID := uResp.OriginalURL, "", ""
+	rule := uResp.TargetingRules.Match(targeting.Visit{
+		OSFamily:    agent.OSFamily,
+		DeviceType:  agent.DeviceType,
+		Language:    targeting.PreferredLanguage(r.Header.Get("Accept-Language")),
+		CountryCode: location.CountryCode,
+		Time:        now,
+	})
+	if rule != nil {
+		target, ruleID = rule.URL, rule.ID
+	} else if variant := h.pickVariant(w, r, uResp); variant != nil {
+		target, variantID = variant.URL, variant.ID
+	}
+
+	if !counted {
+		metrics.RedirectsTotal.WithLabelValues(metrics.OutcomeHead).Inc()
+		h.redirect(w, r, uResp, h.destination(uResp, target, r))
+		return
+	}
+
+	// Anonymize the IP address after it was geolocated
+	accessedAt := time.Now()
+	storedIP, err := h.Anonymizer.Anonymize(r.Context(), ipAddress, accessedAt)
+	if err != nil {
+		config.Warn("Recording truncated IP address, hashing failed: %v", err)
+	}
+
+	err = h.Store.AccessLogs.CreateLog(r.Context(), &store.AccessLog{
+		IPAddress:      storedIP,
+		IPAnonymized:   h.Anonymizer.Enabled(),
+		UserAgent:      userAgent,
+		ShortURLID:     int64(uResp.ID),
+		AccessedAt:     accessedAt,
+		Referrer:       referrer.URL,
+		ReferrerDomain: referrer.Domain,
+		BrowserFamily:  agent.BrowserFamily,
+		BrowserVersion: agent.BrowserVersion,
+		OSFamily:       agent.OSFamily,
+		OSVersion:      agent.OSVersion,
+		DeviceType:     agent.DeviceType,
+		IsBot:          isBot,
+		CountryCode:    location.CountryCode,
+		RegionCode:     location.RegionCode,
+		City:           location.City,
+		TargetingRule:  ruleID,
+		Variant:        variantID,
+	})
+	if err != nil {
+		// The click is already counted, one-time short URLs included: the visitor is
+		// sent on even though its details are lost.
+		config.Warn("Recording click of %s: %v", uResp.ShortCode, err)
+	}
+
+	click := events.Click{
+		ShortCode:      uResp.ShortCode,
+		OwnerID:        uResp.OwnerID,
+		AccessedAt:     accessedAt,
+		ReferrerDomain: referrer.Domain,
+		BrowserFamily:  agent.BrowserFamily,
+		OSFamily:       agent.OSFamily,
+		DeviceType:     agent.DeviceType,
+		CountryCode:    location.CountryCode,
+		IsBot:          isBot,
+	}
+
+	// Notify the open click streams and the webhooks, the redirect doesn't depend on it
+	if err := h.Clicks.Publish(r.Context(), click); err != nil {
+		config.Warn("Publishing click of %s: %v", uResp.ShortCode, err)
+	}
+	h.Webhooks.Click(uResp, click, count)
+
+	metrics.RedirectsTotal.WithLabelValues(metrics.OutcomeHit).Inc()
+	h.redirect(w, r, uResp, h.destination(uResp, target, r))
+}
+
+// maxClicks returns the click limit of a short URL created with req, nil if unlimited.
+func (h *Handler) maxClicks(req *URLRequest) (*int, error) {
+	if req.OneTime {
+		if req.MaxClicks != nil && *req.MaxClicks != 1 {
+			return nil, errors.New("one_time can't be combined with max_clicks")
 		}
+		one := 1
+		return &one, nil
+	}
 
-		// Check if the original URL already has query parameters
-		if strings.Contains(redirectURL, "?") {
-			redirectURL += "&" + params.Encode()
-		} else {
-			redirectURL += "?" + params.Encode()
+	if req.MaxClicks == nil {
+		if limit := h.Config.MaxRedirectsPerURL; limit > 0 {
+			return &limit, nil
 		}
+		return nil, nil
 	}
 
-	http.Redirect(w, r, redirectURL, http.StatusFound)
+	switch {
+	case *req.MaxClicks < 0:
+		return nil, errors.New("max_clicks must not be negative")
+	case *req.MaxClicks == 0:
+		return nil, nil
+	}
+	return req.MaxClicks, nil
 }
 
 func (h *Handler) FindWithURL(w http.ResponseWriter, r *http.Request) {
@@ -201,7 +547,7 @@ func (h *Handler) FindWithURL(w http.ResponseWriter, r *http.Request) {
 	response := struct {
 		Shortener interface{} `json:"shortener"`
 	}{
-		Shortener: uResp,
+		Shortener: h.withoutDestinations(r, uResp),
 	}
 
 	// Set content type header
@@ -222,7 +568,13 @@ func (h *Handler) LastAccessed(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	uResp, err := h.Store.AccessLogs.LastAccessed(r.Context(), shortURL)
+	includeBots, err := parseIncludeBots(r.URL.Query().Get("include_bots"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	uResp, err := h.Store.AccessLogs.LastAccessed(r.Context(), shortURL, includeBots)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -253,7 +605,13 @@ func (h *Handler) TopUserAgents(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	uResp, err := h.Store.AccessLogs.TopUserAgents(r.Context(), shortURL)
+	includeBots, err := parseIncludeBots(r.URL.Query().Get("include_bots"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	uResp, err := h.Store.AccessLogs.TopUserAgents(r.Context(), shortURL, includeBots)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -284,7 +642,13 @@ func (h *Handler) UniqueIPs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	uResp, err := h.Store.AccessLogs.UniqueIPAddresses(r.Context(), shortURL)
+	includeBots, err := parseIncludeBots(r.URL.Query().Get("include_bots"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	uResp, err := h.Store.AccessLogs.UniqueIPAddresses(r.Context(), shortURL, includeBots)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -307,3 +671,15 @@ func (h *Handler) UniqueIPs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// writeJSON writes v as the JSON response body with the given status code.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	// Set content type header
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	// Encode and send the response
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
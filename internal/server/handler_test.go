@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/nccapo/url-sh/internal/store"
+	"github.com/nccapo/url-sh/internal/targeting"
+)
+
+func TestRedirectClickLimits(t *testing.T) {
+	one, three := 1, 3
+
+	tests := []struct {
+		name      string
+		maxClicks *int
+		// stale looks up the short URL as if no click was counted yet.
+		stale bool
+		want  []int
+	}{
+		{"unlimited", nil, false, []int{http.StatusFound, http.StatusFound, http.StatusFound, http.StatusFound}},
+		{"one-time", &one, false, []int{http.StatusFound, http.StatusGone, http.StatusGone}},
+		{"limited", &three, false, []int{http.StatusFound, http.StatusFound, http.StatusFound, http.StatusGone}},
+		{"limit reached while following", &one, true, []int{http.StatusFound, http.StatusGone, http.StatusGone}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, accessLogs := newTestHandler(t, store.URLShortener{ShortCode: "code", OriginalURL: "https://example.com", MaxClicks: tt.maxClicks})
+			h.Store.Shortener.(*memoryShortener).stale = tt.stale
+
+			for i, want := range tt.want {
+				w := serve(h, browserRequest(http.MethodGet, "/code", nil))
+				if w.Code != want {
+					t.Fatalf("click %d: status = %d, want %d", i+1, w.Code, want)
+				}
+				if want == http.StatusGone && w.Header().Get("Location") != "" {
+					t.Errorf("click %d: exhausted short URL redirected to %q", i+1, w.Header().Get("Location"))
+				}
+			}
+
+			redirects := 0
+			for _, code := range tt.want {
+				if code == http.StatusFound {
+					redirects++
+				}
+			}
+			if accessLogs.count() != redirects {
+				t.Errorf("%d clicks recorded, want %d", accessLogs.count(), redirects)
+			}
+		})
+	}
+}
+
+func TestRedirectCountsOneTimeLinkWhenRecordingFails(t *testing.T) {
+	one := 1
+	h, accessLogs := newTestHandler(t, store.URLShortener{ShortCode: "once", OriginalURL: "https://example.com", MaxClicks: &one})
+	accessLogs.err = errors.New("database down")
+
+	// The click was counted, so the visitor is sent on even though it isn't recorded.
+	w := serve(h, browserRequest(http.MethodGet, "/once", nil))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://example.com" {
+		t.Fatalf("status = %d, location = %q, want a redirect", w.Code, w.Header().Get("Location"))
+	}
+
+	if w := serve(h, browserRequest(http.MethodGet, "/once", nil)); w.Code != http.StatusGone {
+		t.Errorf("second click: status = %d, want %d", w.Code, http.StatusGone)
+	}
+}
+
+func TestRedirectHeadIsNotAClick(t *testing.T) {
+	one := 1
+	h, accessLogs := newTestHandler(t,
+		store.URLShortener{ShortCode: "once", OriginalURL: "https://example.com/once", MaxClicks: &one},
+		store.URLShortener{ShortCode: "sticky", OriginalURL: "https://example.com", StickyVariants: true, Variants: targeting.Variants{
+			{ID: "a", URL: "https://example.com/a", Weight: 1},
+		}},
+	)
+
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"once", "https://example.com/once"},
+		{"sticky", "https://example.com/a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			// A one-time short URL can be checked any number of times before it's followed.
+			for i := range 3 {
+				w := serve(h, browserRequest(http.MethodHead, "/"+tt.code, nil))
+				if w.Code != http.StatusFound || w.Header().Get("Location") != tt.want {
+					t.Fatalf("HEAD %d: status = %d, location = %q, want a redirect to %q", i+1, w.Code, w.Header().Get("Location"), tt.want)
+				}
+				if cookie := responseCookie(w, variantCookie); cookie != nil {
+					t.Errorf("HEAD %d: visitor was assigned variant %q", i+1, cookie.Value)
+				}
+			}
+			if accessLogs.count() != 0 {
+				t.Fatalf("%d clicks recorded by HEAD requests", accessLogs.count())
+			}
+
+			link, err := h.Store.Shortener.FindWithShortCode(context.Background(), tt.code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if link.RedirectCount != 0 {
+				t.Errorf("redirect count = %d after HEAD requests, want 0", link.RedirectCount)
+			}
+		})
+	}
+
+	if w := serve(h, browserRequest(http.MethodGet, "/once", nil)); w.Code != http.StatusFound {
+		t.Errorf("GET after HEAD requests: status = %d, want %d", w.Code, http.StatusFound)
+	}
+}
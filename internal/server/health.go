@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nccapo/url-sh/internal/db"
+)
+
+// readinessTimeout bounds the time spent on all readiness checks of a single probe.
+const readinessTimeout = 2 * time.Second
+
+// The click pipeline is saturated once maxOverdueDeliveries of the events queued by
+// clicks have been waiting for longer than overdueLag to be delivered.
+const (
+	overdueLag           = 5 * time.Minute
+	maxOverdueDeliveries = 1000
+)
+
+// ReadinessCheck is a named dependency check run by the readiness probe.
+type ReadinessCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+type checkResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type healthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+// Healthz reports that the process is alive. It never touches dependencies.
+func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// Readyz reports whether the service can serve traffic, with the outcome of every check.
+func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	response := healthResponse{Status: "ok", Checks: map[string]checkResult{}}
+	code := http.StatusOK
+
+	for _, c := range h.readinessChecks() {
+		if err := c.Check(ctx); err != nil {
+			response.Checks[c.Name] = checkResult{Status: "fail", Error: err.Error()}
+			response.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+			continue
+		}
+		response.Checks[c.Name] = checkResult{Status: "ok"}
+	}
+
+	writeHealth(w, code, response)
+}
+
+// readinessChecks returns the built-in checks followed by any registered with the handler.
+func (h *Handler) readinessChecks() []ReadinessCheck {
+	checks := []ReadinessCheck{
+		{Name: "database", Check: h.DB.PingContext},
+		{Name: "migrations", Check: h.checkMigrations},
+		{Name: "click_pipeline", Check: h.checkClickPipeline},
+	}
+	return append(checks, h.Checks...)
+}
+
+// checkMigrations verifies the schema is clean and at the version this binary expects.
+func (h *Handler) checkMigrations(ctx context.Context) error {
+	version, dirty, err := db.MigrationVersion(ctx, h.DB)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("schema version %d is dirty", version)
+	}
+	if version != db.SchemaVersion {
+		return fmt.Errorf("schema version %d, expected %d", version, db.SchemaVersion)
+	}
+	return nil
+}
+
+// checkClickPipeline verifies the webhook dispatchers, run by every replica, keep up
+// with the events queued by clicks.
+func (h *Handler) checkClickPipeline(ctx context.Context) error {
+	overdue, err := h.Store.Webhooks.CountOverdue(ctx, overdueLag, maxOverdueDeliveries)
+	if err != nil {
+		return err
+	}
+	if overdue >= maxOverdueDeliveries {
+		return fmt.Errorf("%d or more webhook deliveries overdue by %s", overdue, overdueLag)
+	}
+	return nil
+}
+
+func writeHealth(w http.ResponseWriter, code int, response healthResponse) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, code, response)
+}
@@ -0,0 +1,380 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nccapo/url-sh/config"
+	"github.com/nccapo/url-sh/internal/store"
+	"github.com/nccapo/url-sh/internal/targeting"
+	"github.com/nccapo/url-sh/internal/webhooks"
+)
+
+// UpdateURLRequest changes the settings of a short URL, omitted fields are left unchanged.
+type UpdateURLRequest struct {
+	URL *string `json:"url"`
+	// UTM Parameters
+	UTMSource   *string `json:"utm_source"`
+	UTMMedium   *string `json:"utm_medium"`
+	UTMCampaign *string `json:"utm_campaign"`
+	UTMTerm     *string `json:"utm_term"`
+	UTMContent  *string `json:"utm_content"`
+	// RetentionDays overrides the global retention of raw clicks, 0 keeps them forever.
+	RetentionDays *int `json:"retention_days"`
+	// RedirectStatus overrides the redirect status of the deployment, 0 resets it.
+	RedirectStatus *int  `json:"redirect_status"`
+	CacheRedirects *bool `json:"cache_redirects"`
+	// Password protects the short URL behind an unlock page, "" removes the protection.
+	Password *string `json:"password"`
+	// MaxClicks is the number of times the short URL can be followed, 0 removes the limit.
+	MaxClicks *int `json:"max_clicks"`
+	// ActiveFrom and ActiveUntil bound the activation window, null opens it on that side.
+	ActiveFrom  nullable[time.Time] `json:"active_from"`
+	ActiveUntil nullable[time.Time] `json:"active_until"`
+	// ScheduledFallbackURL and EndedFallbackURL are where visitors are sent before
+	// and after the activation window, "" uses the fallback of the deployment.
+	ScheduledFallbackURL *string `json:"scheduled_fallback_url"`
+	EndedFallbackURL     *string `json:"ended_fallback_url"`
+	// TargetingRules replace the targeting rules, [] removes them.
+	TargetingRules *targeting.Rules `json:"targeting_rules"`
+	// Variants replace the variants, [] removes them.
+	Variants       *targeting.Variants `json:"variants"`
+	StickyVariants *bool               `json:"sticky_variants"`
+	// ForwardQuery, ForwardPath and QueryPrecedence configure the passthrough of
+	// the query string and sub-path of the short URL.
+	ForwardQuery    *bool   `json:"forward_query"`
+	ForwardPath     *bool   `json:"forward_path"`
+	QueryPrecedence *string `json:"query_precedence"`
+	// UTMPolicy is override or keep, "" uses the policy of the deployment.
+	UTMPolicy *string `json:"utm_policy"`
+	// TrackingParams replace the tracking parameters, {} removes them.
+	TrackingParams *store.TrackingParams `json:"tracking_params"`
+	// Campaign is the name of a campaign of the owner whose parameters are applied
+	// to the short URL before the other fields of the request.
+	Campaign *string `json:"campaign"`
+	// Preview serves a preview page in place of the redirect.
+	Preview *bool `json:"preview"`
+	// OGTitle, OGDescription and OGImage are the Open Graph metadata of the preview
+	// page, "" removes them.
+	OGTitle       *string `json:"og_title"`
+	OGDescription *string `json:"og_description"`
+	OGImage       *string `json:"og_image"`
+}
+
+// nullable is an optional JSON field that can be set to null, unlike a pointer,
+// which can't tell null from an omitted field.
+type nullable[T any] struct {
+	// Set reports whether the field was present.
+	Set   bool
+	Value *T
+}
+
+func (n *nullable[T]) UnmarshalJSON(data []byte) error {
+	n.Set = true
+	n.Value = nil
+	if string(data) == "null" {
+		return nil
+	}
+	n.Value = new(T)
+	return json.Unmarshal(data, n.Value)
+}
+
+// ListURLs lists the short URLs of the owner of the request, optionally restricted
+// to a state of their activation window: scheduled, active or ended.
+func (h *Handler) ListURLs(w http.ResponseWriter, r *http.Request) {
+	owner, ok := h.requireOwner(w, r)
+	if !ok {
+		return
+	}
+
+	state := r.URL.Query().Get("state")
+	if state != "" && !store.IsLinkState(state) {
+		http.Error(w, "state must be one of scheduled, active or ended", http.StatusBadRequest)
+		return
+	}
+
+	limit, err := parseLimit(r.URL.Query().Get("limit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	links, err := h.Store.Shortener.ListByOwner(r.Context(), owner, state, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Shorteners interface{} `json:"shorteners"`
+	}{
+		Shorteners: links,
+	})
+}
+
+// UpdateURL changes the settings of a short URL of the owner of the request.
+func (h *Handler) UpdateURL(w http.ResponseWriter, r *http.Request) {
+	link, ok := h.ownedURL(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Campaign != nil {
+		campaign, ok := h.campaign(w, r, link.OwnerID, *req.Campaign)
+		if !ok {
+			return
+		}
+		applyCampaign(link, campaign)
+	}
+
+	if err := applyURLUpdate(link, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	updated, err := h.Store.Shortener.Update(r.Context(), link)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.notifyLink(r, webhooks.EventLinkUpdated, updated)
+
+	writeJSON(w, http.StatusOK, struct {
+		Shortener interface{} `json:"shortener"`
+	}{
+		Shortener: updated,
+	})
+}
+
+// DeleteURL deletes a short URL of the owner of the request.
+func (h *Handler) DeleteURL(w http.ResponseWriter, r *http.Request) {
+	link, ok := h.ownedURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.Store.Shortener.Delete(r.Context(), link.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.notifyLink(r, webhooks.EventLinkDeleted, link)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ownedURL finds the short URL of the code in the path and checks that it belongs to
+// the owner of the request. Anonymous short URLs can't be changed by anyone.
+func (h *Handler) ownedURL(w http.ResponseWriter, r *http.Request) (*store.URLShortener, bool) {
+	owner, ok := h.requireOwner(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	link, err := h.Store.Shortener.FindWithShortCode(r.Context(), r.PathValue("code"))
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if link.OwnerID != owner {
+		http.Error(w, "short URL belongs to another owner", http.StatusForbidden)
+		return nil, false
+	}
+	return link, true
+}
+
+// applyURLUpdate validates req and applies it to link.
+func applyURLUpdate(link *store.URLShortener, req *UpdateURLRequest) error {
+	if req.URL != nil {
+		if *req.URL == "" {
+			return errors.New("url must not be empty")
+		}
+		link.OriginalURL = *req.URL
+	}
+
+	if req.UTMSource != nil {
+		link.UTMSource = *req.UTMSource
+	}
+	if req.UTMMedium != nil {
+		link.UTMMedium = *req.UTMMedium
+	}
+	if req.UTMCampaign != nil {
+		link.UTMCampaign = *req.UTMCampaign
+	}
+	if req.UTMTerm != nil {
+		link.UTMTerm = *req.UTMTerm
+	}
+	if req.UTMContent != nil {
+		link.UTMContent = *req.UTMContent
+	}
+
+	if req.RetentionDays != nil {
+		if *req.RetentionDays < 0 {
+			return errors.New("retention_days must not be negative")
+		}
+		link.RetentionDays = req.RetentionDays
+	}
+
+	if req.RedirectStatus != nil {
+		switch {
+		case *req.RedirectStatus == 0:
+			link.RedirectStatus = nil
+		case isRedirectStatus(*req.RedirectStatus):
+			link.RedirectStatus = req.RedirectStatus
+		default:
+			return fmt.Errorf("redirect_status must be one of 301, 302, 307 or 308, got %d", *req.RedirectStatus)
+		}
+	}
+
+	if req.CacheRedirects != nil {
+		link.CacheRedirects = *req.CacheRedirects
+	}
+
+	if req.MaxClicks != nil {
+		switch {
+		case *req.MaxClicks < 0:
+			return errors.New("max_clicks must not be negative")
+		case *req.MaxClicks == 0:
+			link.MaxClicks = nil
+		default:
+			link.MaxClicks = req.MaxClicks
+		}
+	}
+
+	if req.ActiveFrom.Set {
+		link.ActiveFrom = req.ActiveFrom.Value
+	}
+	if req.ActiveUntil.Set {
+		link.ActiveUntil = req.ActiveUntil.Value
+	}
+	if req.ScheduledFallbackURL != nil {
+		link.ScheduledFallbackURL = *req.ScheduledFallbackURL
+	}
+	if req.EndedFallbackURL != nil {
+		link.EndedFallbackURL = *req.EndedFallbackURL
+	}
+	if err := validateSchedule(link.ActiveFrom, link.ActiveUntil, link.ScheduledFallbackURL, link.EndedFallbackURL); err != nil {
+		return err
+	}
+
+	if req.TargetingRules != nil {
+		if err := req.TargetingRules.Normalize(); err != nil {
+			return err
+		}
+		link.TargetingRules = *req.TargetingRules
+	}
+
+	if req.Variants != nil {
+		if err := req.Variants.Normalize(); err != nil {
+			return err
+		}
+		link.Variants = *req.Variants
+	}
+	if req.StickyVariants != nil {
+		link.StickyVariants = *req.StickyVariants
+	}
+
+	if req.ForwardQuery != nil {
+		link.ForwardQuery = *req.ForwardQuery
+	}
+	if req.ForwardPath != nil {
+		link.ForwardPath = *req.ForwardPath
+	}
+	if req.QueryPrecedence != nil {
+		if err := validateQueryPrecedence(*req.QueryPrecedence); err != nil {
+			return err
+		}
+		link.QueryPrecedence = *req.QueryPrecedence
+	}
+
+	if req.UTMPolicy != nil {
+		if err := validateUTMPolicy(*req.UTMPolicy); err != nil {
+			return err
+		}
+		link.UTMPolicy = *req.UTMPolicy
+	}
+	if req.TrackingParams != nil {
+		if err := validateTrackingParams(*req.TrackingParams); err != nil {
+			return err
+		}
+		link.TrackingParams = *req.TrackingParams
+	}
+
+	if req.Preview != nil {
+		link.Preview = *req.Preview
+	}
+	if req.OGTitle != nil {
+		link.OGTitle = *req.OGTitle
+	}
+	if req.OGDescription != nil {
+		link.OGDescription = *req.OGDescription
+	}
+	if req.OGImage != nil {
+		link.OGImage = *req.OGImage
+	}
+	if err := validateOpenGraph(link.OGTitle, link.OGDescription, link.OGImage); err != nil {
+		return err
+	}
+
+	if req.Password != nil {
+		link.PasswordHash = ""
+		if *req.Password != "" {
+			hash, err := hashPassword(*req.Password)
+			if err != nil {
+				return err
+			}
+			link.PasswordHash = hash
+		}
+	}
+
+	return nil
+}
+
+// requireOwner returns the owner of the request, or rejects anonymous requests.
+func (h *Handler) requireOwner(w http.ResponseWriter, r *http.Request) (string, bool) {
+	owner := h.ownerID(r)
+	if owner == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "bearer token is required", http.StatusUnauthorized)
+		return "", false
+	}
+	return owner, true
+}
+
+// notifyLink queues a link lifecycle event, the request doesn't depend on it.
+func (h *Handler) notifyLink(r *http.Request, event string, link *store.URLShortener) {
+	if err := h.Webhooks.LinkEvent(r.Context(), event, link); err != nil {
+		config.Warn("Queueing %s webhooks of %s: %v", event, link.ShortCode, err)
+	}
+}
+
+func validateQueryPrecedence(precedence string) error {
+	if precedence != store.QueryPrecedenceStored && precedence != store.QueryPrecedenceIncoming {
+		return fmt.Errorf("query_precedence must be stored or incoming, got %q", precedence)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/nccapo/url-sh/config"
+	"github.com/nccapo/url-sh/internal/analytics"
+	"github.com/nccapo/url-sh/internal/events"
+	"github.com/nccapo/url-sh/internal/store"
+	"github.com/nccapo/url-sh/internal/webhooks"
+)
+
+// memoryShortener is an in-memory store of short URLs. The methods the tests don't
+// use are left to the embedded, unconnected PostgresURLShortener.
+type memoryShortener struct {
+	*store.PostgresURLShortener
+
+	mu    sync.Mutex
+	links []store.URLShortener
+	// stale makes FindWithShortCode return the short URLs as they were first stored,
+	// like a lookup racing the clicks of other requests.
+	stale   bool
+	initial []store.URLShortener
+}
+
+func (m *memoryShortener) FindWithShortCode(_ context.Context, shortCode string) (*store.URLShortener, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	links := m.links
+	if m.stale {
+		links = m.initial
+	}
+	for _, link := range links {
+		if link.ShortCode == shortCode {
+			if link.MaxClicks != nil {
+				remaining := max(*link.MaxClicks-link.RedirectCount-link.BotRedirectCount, 0)
+				link.RemainingClicks = &remaining
+			}
+			return &link, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (m *memoryShortener) UpdateRedirectCount(_ context.Context, id int, bot bool) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.links {
+		link := &m.links[i]
+		if link.ID != id {
+			continue
+		}
+		if link.MaxClicks != nil && link.RedirectCount+link.BotRedirectCount >= *link.MaxClicks {
+			return 0, store.ErrClickLimitReached
+		}
+		if bot {
+			link.BotRedirectCount++
+			return link.BotRedirectCount, nil
+		}
+		link.RedirectCount++
+		return link.RedirectCount, nil
+	}
+	return 0, store.ErrClickLimitReached
+}
+
+// memoryAccessLogs is an in-memory store of clicks.
+type memoryAccessLogs struct {
+	*store.PostgresAccessLogs
+
+	mu   sync.Mutex
+	logs []store.AccessLog
+	// err fails the clicks recorded while set.
+	err error
+}
+
+func (m *memoryAccessLogs) CreateLog(_ context.Context, log *store.AccessLog) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return m.err
+	}
+	m.logs = append(m.logs, *log)
+	return nil
+}
+
+// Breakdown only supports the variant dimension, and ignores the short code: the
+// tests record the clicks of a single short URL.
+func (m *memoryAccessLogs) Breakdown(_ context.Context, _ string, _ string, _ int, includeBots bool) ([]store.DimensionCount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clicks := map[string]int64{}
+	for _, log := range m.logs {
+		if log.Variant != "" && (includeBots || !log.IsBot) {
+			clicks[log.Variant]++
+		}
+	}
+
+	var counts []store.DimensionCount
+	for value, count := range clicks {
+		counts = append(counts, store.DimensionCount{Value: value, Clicks: count})
+	}
+	return counts, nil
+}
+
+// count returns the number of recorded clicks.
+func (m *memoryAccessLogs) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.logs)
+}
+
+// memoryWebhooks queues nothing, the tests' short URLs have no webhooks.
+type memoryWebhooks struct {
+	*store.PostgresWebhooks
+}
+
+func (memoryWebhooks) Enqueue(context.Context, string, string, []byte) (int64, error) {
+	return 0, nil
+}
+
+func (memoryWebhooks) EnqueueClickThreshold(context.Context, string, string, int64, []byte) (int64, error) {
+	return 0, nil
+}
+
+const testSecretKey = "test-secret-key"
+
+// newTestHandler returns a handler serving links from memory, and the store of their clicks.
+func newTestHandler(t *testing.T, links ...store.URLShortener) (*Handler, *memoryAccessLogs) {
+	t.Helper()
+
+	for i := range links {
+		links[i].ID = i + 1
+		if links[i].IID == uuid.Nil {
+			links[i].IID = uuid.New()
+		}
+	}
+
+	accessLogs := &memoryAccessLogs{}
+	st := &store.Store{
+		Shortener:  &memoryShortener{links: links, initial: slices.Clone(links)},
+		AccessLogs: accessLogs,
+		Webhooks:   memoryWebhooks{},
+	}
+
+	broker := events.NewBroker(1)
+	t.Cleanup(broker.Close)
+	notifier := webhooks.NewNotifier(st)
+	t.Cleanup(notifier.Close)
+
+	cfg := &config.Config{
+		SecretKey: testSecretKey,
+		RedirectConfig: &config.RedirectConfig{
+			Status:            http.StatusFound,
+			CacheMaxAge:       time.Hour,
+			UTMPolicy:         store.UTMPolicyOverride,
+			UnlockTTL:         time.Hour,
+			UnlockMaxAttempts: 3,
+			UnlockWindow:      time.Minute,
+			VariantCookieTTL:  time.Hour,
+		},
+		AnalyticsConfig: &config.AnalyticsConfig{PrivacyMode: config.PrivacyModeOff},
+	}
+
+	return &Handler{
+		Store:          st,
+		Config:         cfg,
+		Anonymizer:     analytics.NewAnonymizer(config.PrivacyModeOff, nil),
+		Broker:         broker,
+		Clicks:         broker,
+		Webhooks:       notifier,
+		unlockAttempts: newAttemptLimiter(cfg.RedirectConfig.UnlockMaxAttempts, cfg.RedirectConfig.UnlockWindow),
+	}, accessLogs
+}
+
+// ownerToken is the bearer token of the owner of the tests' short URLs.
+const ownerToken = "owner-token"
+
+// testOwnerID returns the owner ID of ownerToken.
+func testOwnerID() string {
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+ownerToken)
+	return (&Handler{Config: &config.Config{SecretKey: testSecretKey}}).ownerID(r)
+}
+
+// newTestMux routes the requests to h like the server does.
+func newTestMux(h *Handler) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/shorten/variants", h.VariantStats)
+	mux.HandleFunc("GET /{code}", h.UpdateVisitsCount)
+	mux.HandleFunc("POST /{code}", h.Unlock)
+	mux.HandleFunc("GET /{code}/{rest...}", h.UpdateVisitsCount)
+	return mux
+}
+
+// browserUserAgent is the User-Agent of the tests' requests, which aren't classified as bots.
+const browserUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36"
+
+// browserRequest returns a request sent by a browser following a link.
+func browserRequest(method, target string, body io.Reader) *http.Request {
+	r := httptest.NewRequest(method, target, body)
+	r.Header.Set("User-Agent", browserUserAgent)
+	r.Header.Set("Accept", "text/html")
+	r.Header.Set("Accept-Language", "en")
+	return r
+}
+
+// serve answers r with h as routed by the server, carrying over cookies.
+func serve(h *Handler, r *http.Request, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	newTestMux(h).ServeHTTP(w, r)
+	return w
+}
+
+// responseCookie returns the cookie named name set by the response, nil if none.
+func responseCookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// ownerID identifies the owner of the request from its bearer token, or returns
+// an empty string for anonymous requests. The token itself is never stored: the
+// owner is the HMAC of the token keyed with the secret key.
+func (h *Handler) ownerID(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, []byte(h.Config.SecretKey))
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/nccapo/url-sh/internal/metrics"
+	"github.com/nccapo/url-sh/internal/store"
+	"github.com/nccapo/url-sh/internal/targeting"
+)
+
+const (
+	// previewSuffix appended to a short code requests the preview page of the short
+	// URL, such as /abc123+.
+	previewSuffix = "+"
+	// continueParam marks the requests continuing from the preview page of short
+	// URLs that always preview, it is never forwarded to the destination.
+	continueParam = "url_sh_continue"
+)
+
+// Bounds of the Open Graph metadata of a short URL.
+const (
+	maxOGTitle       = 200
+	maxOGDescription = 1000
+)
+
+// previewPage is the data of the preview template.
+type previewPage struct {
+	Title       string
+	Description string
+	Image       string
+	ShortURL    string
+	// Destination is empty when Locked.
+	Destination string
+	Locked      bool
+	CreatedAt   time.Time
+	Clicks      int
+	ContinueURL string
+}
+
+// wantsPreview reports whether the request is served the preview page of link in
+// place of the redirect: it was requested with the preview suffix, the short URL
+// always previews and the visitor isn't continuing from the preview page, or the
+// visitor is a bot, such as a social unfurler, and the short URL has Open Graph
+// metadata of its own.
+func wantsPreview(r *http.Request, link *store.URLShortener, suffixed, isBot bool) bool {
+	switch {
+	case suffixed:
+		return true
+	case link.Preview && !r.URL.Query().Has(continueParam):
+		return true
+	default:
+		return isBot && link.HasOpenGraph()
+	}
+}
+
+// servePreview serves the preview page of link. It is not counted as a click, and
+// the destination of password-protected short URLs is only shown once unlocked.
+func (h *Handler) servePreview(w http.ResponseWriter, r *http.Request, link *store.URLShortener) {
+	metrics.RedirectsTotal.WithLabelValues(metrics.OutcomePreview).Inc()
+
+	// Continuing follows the short URL with the sub-path and query it was previewed with.
+	next := url.URL{Path: "/" + link.ShortCode}
+	if rest := r.PathValue("rest"); rest != "" {
+		next.Path += "/" + rest
+	}
+	query := r.URL.Query()
+	if link.Preview {
+		query.Set(continueParam, "1")
+	}
+	next.RawQuery = query.Encode()
+
+	page := previewPage{
+		Title:       link.OGTitle,
+		Description: link.OGDescription,
+		Image:       link.OGImage,
+		ShortURL:    link.ShortURL,
+		Locked:      link.PasswordProtected && !h.isUnlocked(r, link),
+		CreatedAt:   link.CreatedAt.UTC(),
+		Clicks:      link.RedirectCount,
+		ContinueURL: next.String(),
+	}
+	if !page.Locked {
+		page.Destination = link.OriginalURL
+	}
+	if page.Title == "" {
+		page.Title = "Link preview"
+	}
+
+	renderPage(w, http.StatusOK, "preview.html", page)
+}
+
+// validateOpenGraph checks the Open Graph metadata of a short URL.
+func validateOpenGraph(title, description, image string) error {
+	if len(title) > maxOGTitle {
+		return fmt.Errorf("og_title must not be longer than %d bytes", maxOGTitle)
+	}
+	if len(description) > maxOGDescription {
+		return fmt.Errorf("og_description must not be longer than %d bytes", maxOGDescription)
+	}
+	if image != "" && !targeting.IsHTTPURL(image) {
+		return errors.New("og_image must be an absolute http or https URL")
+	}
+	return nil
+}
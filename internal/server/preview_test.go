@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/nccapo/url-sh/internal/store"
+)
+
+func TestPreview(t *testing.T) {
+	hash, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, accessLogs := newTestHandler(t,
+		store.URLShortener{ShortCode: "plain", OriginalURL: "https://example.com/plain"},
+		store.URLShortener{ShortCode: "always", OriginalURL: "https://example.com/always", Preview: true},
+		store.URLShortener{ShortCode: "og", OriginalURL: "https://example.com/og", OGTitle: "Launch day"},
+		store.URLShortener{ShortCode: "locked", OriginalURL: "https://example.com/secret", PasswordHash: hash, PasswordProtected: true},
+	)
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		// want is the destination of the redirect, empty for the preview page.
+		want string
+		// shown are strings the preview page must contain, hidden ones it must not.
+		shown, hidden []string
+	}{
+		{name: "redirect", req: browserRequest(http.MethodGet, "/plain", nil), want: "https://example.com/plain"},
+		{name: "suffix", req: browserRequest(http.MethodGet, "/plain+", nil), shown: []string{"https://example.com/plain", `href="/plain"`}},
+		{name: "always", req: browserRequest(http.MethodGet, "/always?ref=x", nil), shown: []string{"https://example.com/always", "url_sh_continue=1"}},
+		{name: "continue", req: browserRequest(http.MethodGet, "/always?url_sh_continue=1", nil), want: "https://example.com/always"},
+		{name: "unfurler", req: unfurlerRequest("/og"), shown: []string{`<meta property="og:title" content="Launch day">`}},
+		{name: "browser on open graph link", req: browserRequest(http.MethodGet, "/og", nil), want: "https://example.com/og"},
+		{name: "unfurler without open graph", req: unfurlerRequest("/plain"), want: "https://example.com/plain"},
+		{name: "locked", req: browserRequest(http.MethodGet, "/locked+", nil), shown: []string{"Hidden until the password is entered"}, hidden: []string{"https://example.com/secret"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := accessLogs.count()
+			w := serve(h, tt.req)
+
+			if tt.want != "" {
+				if w.Code != http.StatusFound || w.Header().Get("Location") != tt.want {
+					t.Fatalf("status = %d, location = %q, want a redirect to %q", w.Code, w.Header().Get("Location"), tt.want)
+				}
+				return
+			}
+
+			if w.Code != http.StatusOK || w.Header().Get("Location") != "" {
+				t.Fatalf("status = %d, location = %q, want the preview page", w.Code, w.Header().Get("Location"))
+			}
+			if accessLogs.count() != before {
+				t.Error("the preview was recorded as a click")
+			}
+			body := w.Body.String()
+			for _, s := range tt.shown {
+				if !strings.Contains(body, s) {
+					t.Errorf("preview page doesn't contain %q", s)
+				}
+			}
+			for _, s := range tt.hidden {
+				if strings.Contains(body, s) {
+					t.Errorf("preview page contains %q", s)
+				}
+			}
+		})
+	}
+}
+
+// unfurlerRequest returns the request of a social unfurler fetching path.
+func unfurlerRequest(path string) *http.Request {
+	r := browserRequest(http.MethodGet, path, nil)
+	r.Header.Set("User-Agent", "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)")
+	return r
+}
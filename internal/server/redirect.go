@@ -0,0 +1,131 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/nccapo/url-sh/internal/store"
+)
+
+// isRedirectStatus reports whether status can be used as the redirect status of a short URL.
+func isRedirectStatus(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// redirectStatus returns the status short URL link redirects with.
+func (h *Handler) redirectStatus(link *store.URLShortener) int {
+	if link.RedirectStatus != nil {
+		return *link.RedirectStatus
+	}
+	return h.Config.RedirectConfig.Status
+}
+
+// redirect sends the redirect of short URL link to target. Redirects, permanent
+// ones included, are only cacheable if the short URL opts in to it: browsers and
+// proxies serve cached redirects without the click reaching the service. Redirects
+// whose destination depends on the visitor are never cacheable, nor are those of
+// password-protected short URLs, which a shared cache would serve unlocked to anyone.
+func (h *Handler) redirect(w http.ResponseWriter, r *http.Request, link *store.URLShortener, target string) {
+	if link.CacheRedirects && !link.PasswordProtected && len(link.TargetingRules) == 0 && len(link.Variants) == 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(h.Config.RedirectConfig.CacheMaxAge.Seconds())))
+	} else {
+		w.Header().Set("Cache-Control", "private, no-store")
+	}
+
+	http.Redirect(w, r, target, h.redirectStatus(link))
+}
+
+// destination builds the URL a click on link is sent to from target, the destination
+// picked for the click. The sub-path of wildcard short URLs is appended to its path,
+// the UTM and tracking parameters of the short URL are merged into its query
+// according to the UTM policy, and so is the query of the request for short URLs
+// forwarding it. The fragment of target is kept. Targets that can't be parsed are
+// used as they are.
+func (h *Handler) destination(link *store.URLShortener, target string, r *http.Request) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+
+	if rest := r.PathValue("rest"); rest != "" && link.ForwardPath {
+		u = u.JoinPath(escapeSubPath(rest))
+	}
+
+	tracking := trackingParams(link)
+	var incoming url.Values
+	if link.ForwardQuery {
+		incoming = r.URL.Query()
+		incoming.Del(continueParam)
+	}
+
+	// The query of the destination is only re-encoded if something is added to it.
+	if len(tracking) > 0 || len(incoming) > 0 {
+		query := u.Query()
+		keep := h.utmPolicy(link) == store.UTMPolicyKeep
+		for key, value := range tracking {
+			if _, set := query[key]; set && keep {
+				continue
+			}
+			query.Set(key, value)
+		}
+		for key, values := range incoming {
+			if _, stored := query[key]; stored && link.QueryPrecedence != store.QueryPrecedenceIncoming {
+				continue
+			}
+			query[key] = values
+		}
+		u.RawQuery = query.Encode()
+	}
+
+	return u.String()
+}
+
+// utmPolicy returns the policy for the parameters the destination of link already has.
+func (h *Handler) utmPolicy(link *store.URLShortener) string {
+	if link.UTMPolicy != "" {
+		return link.UTMPolicy
+	}
+	return h.Config.RedirectConfig.UTMPolicy
+}
+
+// trackingParams returns the UTM and tracking parameters of link that are set.
+func trackingParams(link *store.URLShortener) map[string]string {
+	params := map[string]string{}
+	for key, value := range link.TrackingParams {
+		params[key] = value
+	}
+	for key, value := range map[string]string{
+		"utm_source":   link.UTMSource,
+		"utm_medium":   link.UTMMedium,
+		"utm_campaign": link.UTMCampaign,
+		"utm_term":     link.UTMTerm,
+		"utm_content":  link.UTMContent,
+	} {
+		if value != "" {
+			params[key] = value
+		}
+	}
+	return params
+}
+
+// escapeSubPath cleans the sub-path a wildcard short URL was requested with, so that
+// it can't climb above the path of the destination, and escapes its segments.
+func escapeSubPath(rest string) string {
+	segments := strings.Split(strings.TrimPrefix(path.Clean("/"+rest), "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	escaped := strings.Join(segments, "/")
+	if strings.HasSuffix(rest, "/") && escaped != "" {
+		escaped += "/"
+	}
+	return escaped
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nccapo/url-sh/internal/metrics"
+	"github.com/nccapo/url-sh/internal/store"
+	"github.com/nccapo/url-sh/internal/targeting"
+)
+
+// inactivePage is the data of the inactive template.
+type inactivePage struct {
+	Scheduled  bool
+	ActiveFrom time.Time
+}
+
+// serveInactive answers a visit of link outside of its activation window: visitors
+// are sent to the fallback URL of the short URL, or of the deployment, or shown a
+// page saying that the short URL isn't available. These visits aren't counted.
+func (h *Handler) serveInactive(w http.ResponseWriter, r *http.Request, link *store.URLShortener, state string, now time.Time) {
+	outcome := metrics.OutcomeEnded
+	if state == store.LinkStateScheduled {
+		outcome = metrics.OutcomeScheduled
+	}
+	metrics.RedirectsTotal.WithLabelValues(outcome).Inc()
+
+	fallback := link.EndedFallbackURL
+	if fallback == "" {
+		fallback = h.Config.RedirectConfig.EndedFallbackURL
+	}
+	if state == store.LinkStateScheduled {
+		fallback = link.ScheduledFallbackURL
+		if fallback == "" {
+			fallback = h.Config.RedirectConfig.ScheduledFallbackURL
+		}
+	}
+
+	if fallback != "" {
+		w.Header().Set("Cache-Control", "private, no-store")
+		http.Redirect(w, r, fallback, http.StatusFound)
+		return
+	}
+
+	if state == store.LinkStateScheduled {
+		w.Header().Set("Retry-After", strconv.Itoa(int(link.ActiveFrom.Sub(now).Seconds())+1))
+		renderPage(w, http.StatusNotFound, "inactive.html", inactivePage{Scheduled: true, ActiveFrom: link.ActiveFrom.UTC()})
+		return
+	}
+	renderPage(w, http.StatusGone, "inactive.html", inactivePage{})
+}
+
+// validateSchedule checks the activation window and fallback URLs of a short URL.
+func validateSchedule(from, until *time.Time, scheduledFallback, endedFallback string) error {
+	if from != nil && until != nil && !from.Before(*until) {
+		return errors.New("active_from must be before active_until")
+	}
+	if scheduledFallback != "" && !targeting.IsHTTPURL(scheduledFallback) {
+		return errors.New("scheduled_fallback_url must be an absolute http or https URL")
+	}
+	if endedFallback != "" && !targeting.IsHTTPURL(endedFallback) {
+		return errors.New("ended_fallback_url must be an absolute http or https URL")
+	}
+	return nil
+}
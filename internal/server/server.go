@@ -2,33 +2,131 @@
 package server
 
 import (
+	"database/sql"
 	"net"
 	"net/http"
 	"strings"
 
-	"github.com/nccapo/url-sh/internal/store"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+
+	"github.com/nccapo/url-sh/config"
+	"github.com/nccapo/url-sh/internal/analytics"
+	"github.com/nccapo/url-sh/internal/events"
+	"github.com/nccapo/url-sh/internal/metrics"
+	"github.com/nccapo/url-sh/internal/webhooks"
 )
 
+// reservedCodes are top-level paths served by the service itself, which
+// therefore can never be used as short codes.
+var reservedCodes = map[string]bool{
+	"healthz": true,
+	"readyz":  true,
+	"metrics": true,
+	"v1":      true,
+}
+
 // Routes creates and returns a new ServeMux with all routes configured.
-func Routes(st *store.Store) *http.ServeMux {
+func Routes(cfg *config.Config, db *sql.DB) *http.ServeMux {
 	mux := http.NewServeMux()
 
 	// Initialize the handler with the store
-	H.Store = st
+	H.Store = cfg.Store
+	H.Config = cfg
+	H.DB = db
+	H.GeoIP = openGeoIP(cfg.AnalyticsConfig.GeoIPDatabase)
+	H.Anonymizer = analytics.NewAnonymizer(cfg.AnalyticsConfig.PrivacyMode, cfg.Store.Privacy)
+	H.Broker = events.NewBroker(cfg.AnalyticsConfig.StreamBuffer)
+	H.Clicks = openClickPublisher(cfg, db, H.Broker)
+	H.Webhooks = webhooks.NewNotifier(cfg.Store)
+	H.unlockAttempts = newAttemptLimiter(cfg.RedirectConfig.UnlockMaxAttempts, cfg.RedirectConfig.UnlockWindow)
+
+	// Probes are registered without tracing so that they don't flood the traces.
+	mux.HandleFunc("GET /healthz", H.Healthz)
+	mux.HandleFunc("GET /readyz", H.Readyz)
+
+	handle(mux, "POST /v1/shorten", H.ShortenURL)
+	handle(mux, "GET /v1/shorten", H.ListURLs)
+	handle(mux, "GET /v1/shorten/{code}", H.GetURLStats)
+	handle(mux, "PATCH /v1/shorten/{code}", H.UpdateURL)
+	handle(mux, "DELETE /v1/shorten/{code}", H.DeleteURL)
+	handle(mux, "GET /v1/shorten/find", H.FindWithURL)
+	// GET patterns match HEAD requests as well, only GET requests are counted as clicks.
+	handle(mux, "GET /{code}", H.UpdateVisitsCount)
+	handle(mux, "POST /{code}", H.Unlock)
+	handle(mux, "GET /{code}/{rest...}", H.UpdateVisitsCount)
+	handle(mux, "POST /{code}/{rest...}", H.Unlock)
+
+	handle(mux, "GET /v1/shorten/last", H.LastAccessed)
+	handle(mux, "GET /v1/shorten/top-agents", H.TopUserAgents)
+	handle(mux, "GET /v1/shorten/ips", H.UniqueIPs)
+	handle(mux, "GET /v1/shorten/clicks", H.ClickSeries)
+	handle(mux, "GET /v1/shorten/top-referrers", H.TopReferrers)
+	handle(mux, "GET /v1/shorten/breakdown", H.Breakdown)
+	handle(mux, "GET /v1/shorten/geo", H.GeoBreakdown)
+	handle(mux, "GET /v1/shorten/variants", H.VariantStats)
+
+	handle(mux, "POST /v1/campaigns", H.CreateCampaign)
+	handle(mux, "GET /v1/campaigns", H.ListCampaigns)
+	handle(mux, "GET /v1/campaigns/{name}", H.GetCampaign)
+	handle(mux, "DELETE /v1/campaigns/{name}", H.DeleteCampaign)
+
+	handle(mux, "POST /v1/webhooks", H.CreateWebhook)
+	handle(mux, "GET /v1/webhooks", H.ListWebhooks)
+	handle(mux, "DELETE /v1/webhooks/{id}", H.DeleteWebhook)
+	handle(mux, "GET /v1/webhooks/{id}/deliveries", H.ListDeliveries)
+	handle(mux, "POST /v1/webhooks/{id}/deliveries/{delivery}/replay", H.ReplayDelivery)
+
+	// Streams are registered without tracing, a span would last as long as the connection.
+	mux.HandleFunc("GET /v1/shorten/{code}/stream", H.StreamClicks)
+	mux.HandleFunc("GET /v1/stream", H.StreamOwnerClicks)
+
+	return mux
+}
 
-	mux.HandleFunc("POST /v1/shorten", H.ShortenURL)
-	mux.HandleFunc("GET /v1/shorten/{code}", H.GetURLStats)
-	mux.HandleFunc("PUT /v1/shorten/{code}", H.UpdateVisitsCount)
-	mux.HandleFunc("GET /v1/shorten/find", H.FindWithURL)
-	mux.HandleFunc("GET /{code}", H.UpdateVisitsCount)
+// openGeoIP opens the GeoIP database at path. Clicks are recorded without location
+// if no database is configured or it can't be opened.
+func openGeoIP(path string) *analytics.GeoIP {
+	geo, err := analytics.OpenGeoIP(path)
+	if err != nil {
+		config.Warn("GeoIP database %s could not be opened, clicks are recorded without location: %v", path, err)
+		return nil
+	}
+	if geo == nil {
+		config.Info("No GeoIP database configured, clicks are recorded without location")
+		return nil
+	}
+
+	geo.OnReload = func(err error) {
+		if err != nil {
+			config.Warn("GeoIP database %s could not be reloaded, keeping the previous one: %v", path, err)
+			return
+		}
+		config.Info("GeoIP database %s reloaded", path)
+	}
+	return geo
+}
 
-	mux.HandleFunc("GET /v1/shorten/last", H.LastAccessed)
-	mux.HandleFunc("GET /v1/shorten/top-agents", H.TopUserAgents)
-	mux.HandleFunc("GET /v1/shorten/ips", H.UniqueIPs)
+// handle registers the handler for the given pattern, wrapped in a server span
+// named after the pattern. Incoming W3C trace context is continued.
+func handle(mux *http.ServeMux, pattern string, handler http.HandlerFunc) {
+	mux.Handle(pattern, otelhttp.NewHandler(handler, pattern))
+}
+
+// AdminRoutes creates and returns a new ServeMux with the operational routes
+// that are served on the admin port.
+func AdminRoutes() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.Handle("GET /metrics", metrics.Handler())
 
 	return mux
 }
 
+// isReservedCode reports whether code would shadow one of the service's own routes.
+func isReservedCode(code string) bool {
+	return reservedCodes[strings.ToLower(code)]
+}
+
 func getIPAddress(r *http.Request) string {
 	// Check for X-Forwarded-For header first
 	forwarded := r.Header.Get("X-Forwarded-For")
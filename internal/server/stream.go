@@ -0,0 +1,152 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/nccapo/url-sh/config"
+	"github.com/nccapo/url-sh/internal/events"
+)
+
+// streamKeepAlive is the interval of the comments sent on idle streams, so that
+// proxies don't close them.
+const streamKeepAlive = 15 * time.Second
+
+// openClickPublisher returns the publisher clicks are sent to the streams with.
+// Clicks are relayed through Postgres if enabled, and delivered in process otherwise.
+func openClickPublisher(cfg *config.Config, db *sql.DB, broker *events.Broker) events.Publisher {
+	if !cfg.AnalyticsConfig.StreamNotify {
+		return broker
+	}
+
+	relay, err := events.NewPostgresRelay(cfg.DBConfig.Addr, db, broker)
+	if err != nil {
+		config.Warn("Click stream relay could not listen, streams only receive the clicks of this replica: %v", err)
+		return broker
+	}
+	return relay
+}
+
+// CloseStreams ends the open click streams, so that they don't hold up a graceful
+// shutdown, and stops relaying clicks.
+func CloseStreams() {
+	if closer, ok := H.Clicks.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			config.Warn("Closing the click stream relay: %v", err)
+		}
+	}
+	if H.Broker != nil {
+		H.Broker.Close()
+	}
+}
+
+// StreamClicks streams the clicks of a short URL created with the bearer token of
+// the request as Server-Sent Events.
+//
+// Query parameters: include_bots (default false).
+func (h *Handler) StreamClicks(w http.ResponseWriter, r *http.Request) {
+	includeBots, err := parseIncludeBots(r.URL.Query().Get("include_bots"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	link, ok := h.ownedURL(w, r)
+	if !ok {
+		return
+	}
+
+	h.streamClicks(w, r, func(click events.Click) bool {
+		return click.ShortCode == link.ShortCode && (includeBots || !click.IsBot)
+	})
+}
+
+// StreamOwnerClicks streams the clicks of every short URL created with the bearer
+// token of the request as Server-Sent Events.
+//
+// Query parameters: include_bots (default false).
+func (h *Handler) StreamOwnerClicks(w http.ResponseWriter, r *http.Request) {
+	owner := h.ownerID(r)
+	if owner == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "bearer token is required", http.StatusUnauthorized)
+		return
+	}
+
+	includeBots, err := parseIncludeBots(r.URL.Query().Get("include_bots"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.streamClicks(w, r, func(click events.Click) bool {
+		return click.OwnerID == owner && (includeBots || !click.IsBot)
+	})
+}
+
+// streamClicks writes the clicks matching filter as "click" events until the client
+// disconnects or the server shuts down. Clicks missed because the client fell behind
+// are reported in a "dropped" event with their count.
+func (h *Handler) streamClicks(w http.ResponseWriter, r *http.Request, filter events.Filter) {
+	sub := h.Broker.Subscribe(filter)
+	if sub == nil {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer h.Broker.Unsubscribe(sub)
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case click, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if dropped := sub.Dropped(); dropped > 0 {
+				err = writeEvent(w, "dropped", struct {
+					Count int64 `json:"count"`
+				}{dropped})
+			}
+			if err == nil {
+				err = writeEvent(w, "click", click)
+			}
+		case <-keepAlive.C:
+			_, err = io.WriteString(w, ": keep-alive\n\n")
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// writeEvent writes a Server-Sent Event with a JSON payload.
+func writeEvent(w io.Writer, event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
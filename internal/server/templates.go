@@ -0,0 +1,27 @@
+package server
+
+import (
+	"embed"
+	"html/template"
+	"net/http"
+
+	"github.com/nccapo/url-sh/config"
+)
+
+//go:embed templates/*.html
+var templateFiles embed.FS
+
+// templates are the HTML pages served in place of a redirect.
+var templates = template.Must(template.ParseFS(templateFiles, "templates/*.html"))
+
+// renderPage writes the named template with data. Pages are specific to the
+// request and never cached.
+func renderPage(w http.ResponseWriter, code int, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(code)
+
+	if err := templates.ExecuteTemplate(w, name, data); err != nil {
+		config.Warn("Rendering %s: %v", name, err)
+	}
+}
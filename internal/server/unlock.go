@@ -0,0 +1,237 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/nccapo/url-sh/internal/metrics"
+	"github.com/nccapo/url-sh/internal/store"
+	"github.com/nccapo/url-sh/internal/targeting"
+)
+
+// unlockCookie is the cookie proving that the password of a short URL was entered.
+// It is scoped to the path of the short URL.
+const unlockCookie = "url_sh_unlock"
+
+// maxUnlockFormSize bounds the body of unlock requests.
+const maxUnlockFormSize = 4 << 10
+
+// unlockPage is the data of the unlock template.
+type unlockPage struct {
+	Error string
+}
+
+// hashPassword returns the bcrypt hash of the password of a short URL.
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		return "", errors.New("password must not be longer than 72 bytes")
+	}
+	return string(hash), err
+}
+
+// Unlock checks the password submitted from the unlock page of a short URL, and on
+// success sets the unlock cookie and sends the browser back to the short URL.
+func (h *Handler) Unlock(w http.ResponseWriter, r *http.Request) {
+	code := r.PathValue("code")
+
+	link, err := h.Store.Shortener.FindWithShortCode(r.Context(), code)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if link.IsExpired(time.Now()) {
+		http.Error(w, "short URL has expired", http.StatusGone)
+		return
+	}
+
+	// The short URL is followed with the query string it was requested with.
+	back := r.URL.Path
+	if r.URL.RawQuery != "" {
+		back += "?" + r.URL.RawQuery
+	}
+
+	if !link.PasswordProtected {
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	// The attempt is counted before the password is checked, so that concurrent
+	// guesses can't all get in before the first wrong one is counted. Attempts are
+	// counted per short URL rather than per client, whose address can be forged:
+	// wrong passwords lock out every visitor until the window ends.
+	now := time.Now()
+	if wait, ok := h.unlockAttempts.allow(code, now); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		renderPage(w, http.StatusTooManyRequests, "unlock.html", unlockPage{Error: "Too many wrong passwords, try again later."})
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUnlockFormSize)
+	password := r.PostFormValue("password")
+
+	if bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) != nil {
+		renderPage(w, http.StatusForbidden, "unlock.html", unlockPage{Error: "Wrong password."})
+		return
+	}
+	h.unlockAttempts.refund(code, now)
+
+	expires := now.Add(h.Config.RedirectConfig.UnlockTTL)
+	http.SetCookie(w, &http.Cookie{
+		Name:     unlockCookie,
+		Value:    strconv.FormatInt(expires.Unix(), 10) + "." + h.unlockMAC(link, expires.Unix()),
+		Path:     "/" + link.ShortCode,
+		Expires:  expires,
+		MaxAge:   int(h.Config.RedirectConfig.UnlockTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// requireUnlocked serves the unlock page unless link isn't password protected or
+// the request carries a valid unlock cookie, and reports whether the request may
+// go on to the target of the short URL.
+func (h *Handler) requireUnlocked(w http.ResponseWriter, r *http.Request, link *store.URLShortener) bool {
+	if !link.PasswordProtected || h.isUnlocked(r, link) {
+		return true
+	}
+
+	metrics.RedirectsTotal.WithLabelValues(metrics.OutcomeLocked).Inc()
+	renderPage(w, http.StatusOK, "unlock.html", unlockPage{})
+	return false
+}
+
+// withoutDestinations returns link as shown to the request outside of its redirect.
+// The destinations of a password-protected short URL, the ones of its targeting
+// rules and variants included, are only shown to its owner.
+func (h *Handler) withoutDestinations(r *http.Request, link *store.URLShortener) *store.URLShortener {
+	if !link.PasswordProtected || (link.OwnerID != "" && h.ownerID(r) == link.OwnerID) {
+		return link
+	}
+
+	hidden := *link
+	hidden.OriginalURL = ""
+	hidden.TargetingRules = make(targeting.Rules, len(link.TargetingRules))
+	for i, rule := range link.TargetingRules {
+		rule.URL = ""
+		hidden.TargetingRules[i] = rule
+	}
+	hidden.Variants = make(targeting.Variants, len(link.Variants))
+	for i, variant := range link.Variants {
+		variant.URL = ""
+		hidden.Variants[i] = variant
+	}
+	return &hidden
+}
+
+// isUnlocked reports whether the request carries an unexpired unlock cookie of link.
+func (h *Handler) isUnlocked(r *http.Request, link *store.URLShortener) bool {
+	for _, cookie := range r.CookiesNamed(unlockCookie) {
+		expires, mac, ok := strings.Cut(cookie.Value, ".")
+		if !ok {
+			continue
+		}
+		unix, err := strconv.ParseInt(expires, 10, 64)
+		if err != nil || time.Now().Unix() > unix {
+			continue
+		}
+		if hmac.Equal([]byte(mac), []byte(h.unlockMAC(link, unix))) {
+			return true
+		}
+	}
+	return false
+}
+
+// unlockMAC signs the unlock cookie of link expiring at the given unix time. The
+// password hash is part of the signature, so changing the password locks the
+// short URL again.
+func (h *Handler) unlockMAC(link *store.URLShortener, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(h.Config.SecretKey))
+	fmt.Fprintf(mac, "unlock:%s:%d:%s", link.IID, expires, link.PasswordHash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// isSecureRequest reports whether the request reached the service, or the proxy in
+// front of it, over HTTPS.
+func isSecureRequest(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// attemptLimiter throttles attempts per key, allowing max of them per window.
+// Attempts that succeed are refunded, so that only the failed ones add up.
+type attemptLimiter struct {
+	max    int
+	window time.Duration
+
+	mu       sync.Mutex
+	attempts map[string]*attemptWindow
+}
+
+// attemptWindow counts the attempts of a key since start.
+type attemptWindow struct {
+	start time.Time
+	count int
+}
+
+// pruneThreshold is the number of tracked keys above which expired windows are dropped.
+const pruneThreshold = 1024
+
+func newAttemptLimiter(max int, window time.Duration) *attemptLimiter {
+	return &attemptLimiter{max: max, window: window, attempts: map[string]*attemptWindow{}}
+}
+
+// allow counts an attempt for key and reports whether it may be made. Once max
+// attempts were counted within the window, it returns how long further attempts
+// are rejected.
+func (l *attemptLimiter) allow(key string, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current, ok := l.attempts[key]
+	if ok && now.Before(current.start.Add(l.window)) {
+		if current.count >= l.max {
+			return current.start.Add(l.window).Sub(now), false
+		}
+		current.count++
+		return 0, true
+	}
+
+	if len(l.attempts) >= pruneThreshold {
+		for k, w := range l.attempts {
+			if !now.Before(w.start.Add(l.window)) {
+				delete(l.attempts, k)
+			}
+		}
+	}
+	l.attempts[key] = &attemptWindow{start: now, count: 1}
+	return 0, true
+}
+
+// refund gives back an attempt counted by allow at the given time, as it succeeded.
+func (l *attemptLimiter) refund(key string, at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current, ok := l.attempts[key]
+	if ok && !at.Before(current.start) && current.count > 0 {
+		current.count--
+	}
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nccapo/url-sh/internal/store"
+)
+
+func TestAttemptLimiterAllowsMaxConcurrentAttempts(t *testing.T) {
+	limiter := newAttemptLimiter(3, time.Minute)
+	now := time.Now()
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := limiter.allow("code", now); ok {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != 3 {
+		t.Errorf("allowed %d concurrent attempts, want 3", allowed)
+	}
+}
+
+func TestAttemptLimiter(t *testing.T) {
+	start := time.Now()
+	limiter := newAttemptLimiter(2, time.Minute)
+
+	steps := []struct {
+		name     string
+		key      string
+		at       time.Duration
+		refund   bool
+		want     bool
+		wantWait time.Duration
+	}{
+		{"first attempt", "a", 0, false, true, 0},
+		{"second attempt", "a", time.Second, false, true, 0},
+		{"over the limit", "a", 10 * time.Second, false, false, 50 * time.Second},
+		{"other key", "b", 10 * time.Second, false, true, 0},
+		{"new window", "a", time.Minute, true, true, 0},
+		{"refunded attempt is not counted", "a", time.Minute + time.Second, false, true, 0},
+		{"last attempt of the new window", "a", time.Minute + 2*time.Second, false, true, 0},
+		{"over the limit of the new window", "a", time.Minute + 3*time.Second, false, false, 57 * time.Second},
+	}
+	for _, step := range steps {
+		now := start.Add(step.at)
+		wait, ok := limiter.allow(step.key, now)
+		if ok != step.want || wait != step.wantWait {
+			t.Fatalf("%s: allow = (%s, %t), want (%s, %t)", step.name, wait, ok, step.wantWait, step.want)
+		}
+		if step.refund {
+			limiter.refund(step.key, now)
+		}
+	}
+}
+
+// unlockForm returns the unlock request of code submitting password.
+func unlockForm(code, password string) *http.Request {
+	r := browserRequest(http.MethodPost, "/"+code, strings.NewReader(url.Values{"password": {password}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestUnlock(t *testing.T) {
+	hash, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, accessLogs := newTestHandler(t,
+		store.URLShortener{ShortCode: "locked", OriginalURL: "https://example.com/secret", PasswordHash: hash, PasswordProtected: true},
+		store.URLShortener{ShortCode: "other", OriginalURL: "https://example.com/other", PasswordHash: hash, PasswordProtected: true},
+	)
+
+	// Without the cookie, the unlock page is served and nothing is counted.
+	w := serve(h, browserRequest(http.MethodGet, "/locked", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Location") != "" || !strings.Contains(w.Body.String(), "password") {
+		t.Fatalf("locked: status = %d, location = %q, want the unlock page", w.Code, w.Header().Get("Location"))
+	}
+	if accessLogs.count() != 0 {
+		t.Fatalf("locked: %d clicks recorded, want none", accessLogs.count())
+	}
+
+	w = serve(h, unlockForm("locked", "wrong"))
+	if w.Code != http.StatusForbidden || responseCookie(w, unlockCookie) != nil {
+		t.Fatalf("wrong password: status = %d, want %d without cookie", w.Code, http.StatusForbidden)
+	}
+
+	w = serve(h, unlockForm("locked?ref=mail", "correct horse"))
+	cookie := responseCookie(w, unlockCookie)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/locked?ref=mail" || cookie == nil {
+		t.Fatalf("right password: status = %d, location = %q, cookie = %v", w.Code, w.Header().Get("Location"), cookie)
+	}
+	if cookie.Path != "/locked" || !cookie.HttpOnly {
+		t.Errorf("cookie path = %q, http only = %t, want scoped to /locked and http only", cookie.Path, cookie.HttpOnly)
+	}
+
+	// The cookie unlocks the short URL it was set for, and only that one.
+	w = serve(h, browserRequest(http.MethodGet, "/locked", nil), cookie)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://example.com/secret" {
+		t.Fatalf("unlocked: status = %d, location = %q", w.Code, w.Header().Get("Location"))
+	}
+	if got := w.Header().Get("Cache-Control"); got != "private, no-store" {
+		t.Errorf("unlocked: Cache-Control = %q, want private, no-store", got)
+	}
+	if accessLogs.count() != 1 {
+		t.Errorf("unlocked: %d clicks recorded, want 1", accessLogs.count())
+	}
+
+	w = serve(h, browserRequest(http.MethodGet, "/other", nil), &http.Cookie{Name: unlockCookie, Value: cookie.Value})
+	if w.Code != http.StatusOK || w.Header().Get("Location") != "" {
+		t.Errorf("cookie of another short URL: status = %d, location = %q, want the unlock page", w.Code, w.Header().Get("Location"))
+	}
+
+	expires, _, _ := strings.Cut(cookie.Value, ".")
+	w = serve(h, browserRequest(http.MethodGet, "/locked", nil), &http.Cookie{Name: unlockCookie, Value: expires + ".forged"})
+	if w.Code != http.StatusOK || w.Header().Get("Location") != "" {
+		t.Errorf("forged cookie: status = %d, location = %q, want the unlock page", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestUnlockRejectsAttemptsOverTheLimit(t *testing.T) {
+	hash, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, _ := newTestHandler(t, store.URLShortener{ShortCode: "locked", OriginalURL: "https://example.com", PasswordHash: hash, PasswordProtected: true})
+
+	for i := range h.Config.RedirectConfig.UnlockMaxAttempts {
+		if w := serve(h, unlockForm("locked", "wrong")); w.Code != http.StatusForbidden {
+			t.Fatalf("attempt %d: status = %d, want %d", i+1, w.Code, http.StatusForbidden)
+		}
+	}
+
+	// Even the right password is rejected until the window ends.
+	w := serve(h, unlockForm("locked", "correct horse"))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" || responseCookie(w, unlockCookie) != nil {
+		t.Errorf("over the limit: status = %d, Retry-After = %q, want %d with Retry-After and no cookie",
+			w.Code, w.Header().Get("Retry-After"), http.StatusTooManyRequests)
+	}
+}
+
+func TestUnlockRefundsTheRightPassword(t *testing.T) {
+	hash, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, _ := newTestHandler(t, store.URLShortener{ShortCode: "locked", OriginalURL: "https://example.com", PasswordHash: hash, PasswordProtected: true})
+
+	// Visitors knowing the password never use up the attempts.
+	for i := range 2 * h.Config.RedirectConfig.UnlockMaxAttempts {
+		if w := serve(h, unlockForm("locked", "correct horse")); w.Code != http.StatusSeeOther {
+			t.Fatalf("attempt %d: status = %d, want %d", i+1, w.Code, http.StatusSeeOther)
+		}
+	}
+}
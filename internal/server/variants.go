@@ -0,0 +1,112 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/nccapo/url-sh/internal/store"
+	"github.com/nccapo/url-sh/internal/targeting"
+)
+
+// variantCookie remembers the variant served to a visitor of a short URL with
+// sticky variants. It is scoped to the path of the short URL.
+const variantCookie = "url_sh_variant"
+
+// variantStats are the clicks a variant was served for.
+type variantStats struct {
+	targeting.Variant
+	Clicks int64 `json:"clicks"`
+	// Share is the fraction of the clicks sent to one of the variants that this one got.
+	Share float64 `json:"share"`
+}
+
+// pickVariant chooses the variant of link served to the request, nil if it has none.
+// With sticky variants, the visitor keeps the variant of their cookie as long as it
+// is served.
+func (h *Handler) pickVariant(w http.ResponseWriter, r *http.Request, link *store.URLShortener) *targeting.Variant {
+	if len(link.Variants) == 0 {
+		return nil
+	}
+
+	if link.StickyVariants {
+		if cookie, err := r.Cookie(variantCookie); err == nil {
+			if variant := link.Variants.Find(cookie.Value); variant != nil {
+				return variant
+			}
+		}
+	}
+
+	variant := link.Variants.Pick()
+	// Visitors are only assigned a variant by the requests counted as clicks
+	if variant != nil && link.StickyVariants && r.Method == http.MethodGet {
+		http.SetCookie(w, &http.Cookie{
+			Name:     variantCookie,
+			Value:    variant.ID,
+			Path:     "/" + link.ShortCode,
+			MaxAge:   int(h.Config.RedirectConfig.VariantCookieTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   isSecureRequest(r),
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return variant
+}
+
+// VariantStats compares the clicks of the variants of a short URL.
+//
+// Query parameters: q (short code) and include_bots (default false). Variants
+// that were removed from the short URL aren't listed.
+func (h *Handler) VariantStats(w http.ResponseWriter, r *http.Request) {
+	shortURL := r.URL.Query().Get("q")
+	if shortURL == "" {
+		http.Error(w, "shortURL is required", http.StatusBadRequest)
+		return
+	}
+
+	includeBots, err := parseIncludeBots(r.URL.Query().Get("include_bots"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	link, err := h.Store.Shortener.FindWithShortCode(r.Context(), shortURL)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	link = h.withoutDestinations(r, link)
+
+	counts, err := h.Store.AccessLogs.Breakdown(r.Context(), shortURL, store.DimensionVariant, maxReportLimit, includeBots)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	clicks := map[string]int64{}
+	for _, count := range counts {
+		clicks[count.Value] = count.Clicks
+	}
+
+	stats := make([]variantStats, len(link.Variants))
+	var total int64
+	for i, variant := range link.Variants {
+		stats[i] = variantStats{Variant: variant, Clicks: clicks[variant.ID]}
+		total += stats[i].Clicks
+	}
+	if total > 0 {
+		for i := range stats {
+			stats[i].Share = float64(stats[i].Clicks) / float64(total)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Variants interface{} `json:"variants"`
+	}{
+		Variants: stats,
+	})
+}
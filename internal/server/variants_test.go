@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nccapo/url-sh/internal/store"
+	"github.com/nccapo/url-sh/internal/targeting"
+)
+
+func TestVariantStatsHidesDestinationsOfProtectedLinks(t *testing.T) {
+	variants := targeting.Variants{
+		{ID: "a", URL: "https://example.com/a", Weight: 1},
+		{ID: "b", URL: "https://example.com/b", Weight: 1},
+	}
+	owner := testOwnerID()
+	h, _ := newTestHandler(t,
+		store.URLShortener{ShortCode: "open", OriginalURL: "https://example.com", Variants: variants, OwnerID: owner},
+		store.URLShortener{ShortCode: "locked", OriginalURL: "https://example.com", Variants: variants, OwnerID: owner, PasswordProtected: true},
+	)
+
+	tests := []struct {
+		name     string
+		code     string
+		token    string
+		wantURLs bool
+	}{
+		{"unprotected", "open", "", true},
+		{"protected, anonymous", "locked", "", false},
+		{"protected, another owner", "locked", "someone-else", false},
+		{"protected, owner", "locked", ownerToken, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/shorten/variants?q="+tt.code, nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			newTestMux(h).ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+			var response struct {
+				Variants []variantStats `json:"variants"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("decoding %s: %v", w.Body, err)
+			}
+			if len(response.Variants) != len(variants) {
+				t.Fatalf("got %d variants, want %d", len(response.Variants), len(variants))
+			}
+			for i, variant := range response.Variants {
+				if variant.ID != variants[i].ID {
+					t.Errorf("variant %d id = %q, want %q", i, variant.ID, variants[i].ID)
+				}
+				if got := variant.URL != ""; got != tt.wantURLs {
+					t.Errorf("variant %q url = %q, want shown: %t", variant.ID, variant.URL, tt.wantURLs)
+				}
+			}
+		})
+	}
+}
+
+func TestRedirectPicksVariants(t *testing.T) {
+	variants := targeting.Variants{
+		{ID: "a", URL: "https://example.com/a", Weight: 1},
+		{ID: "paused", URL: "https://example.com/paused", Weight: 0},
+	}
+	h, accessLogs := newTestHandler(t, store.URLShortener{ShortCode: "split", OriginalURL: "https://example.com", Variants: variants})
+
+	for i := range 20 {
+		w := serve(h, browserRequest(http.MethodGet, "/split", nil))
+		if w.Header().Get("Location") != "https://example.com/a" {
+			t.Fatalf("click %d: location = %q, want the only served variant", i+1, w.Header().Get("Location"))
+		}
+		if w.Header().Get("Cache-Control") != "private, no-store" {
+			t.Errorf("click %d: Cache-Control = %q, want private, no-store", i+1, w.Header().Get("Cache-Control"))
+		}
+		if responseCookie(w, variantCookie) != nil {
+			t.Errorf("click %d: variant cookie set for a short URL without sticky variants", i+1)
+		}
+	}
+
+	for _, log := range accessLogs.logs {
+		if log.Variant != "a" {
+			t.Errorf("click recorded with variant %q, want a", log.Variant)
+		}
+	}
+}
+
+func TestRedirectStickyVariants(t *testing.T) {
+	variants := targeting.Variants{
+		{ID: "a", URL: "https://example.com/a", Weight: 1},
+		{ID: "b", URL: "https://example.com/b", Weight: 1},
+		{ID: "paused", URL: "https://example.com/paused", Weight: 0},
+	}
+	h, _ := newTestHandler(t, store.URLShortener{ShortCode: "sticky", OriginalURL: "https://example.com", Variants: variants, StickyVariants: true})
+
+	w := serve(h, browserRequest(http.MethodGet, "/sticky", nil))
+	cookie := responseCookie(w, variantCookie)
+	if cookie == nil {
+		t.Fatal("no variant cookie set")
+	}
+	if cookie.Path != "/sticky" {
+		t.Errorf("cookie path = %q, want /sticky", cookie.Path)
+	}
+	first := w.Header().Get("Location")
+
+	// The visitor keeps being sent to the variant of their cookie.
+	for i := range 20 {
+		w := serve(h, browserRequest(http.MethodGet, "/sticky", nil), cookie)
+		if got := w.Header().Get("Location"); got != first {
+			t.Fatalf("click %d: location = %q, want %q", i+2, got, first)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		variant string
+	}{
+		{"paused variant", "paused"},
+		{"removed variant", "removed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, browserRequest(http.MethodGet, "/sticky", nil), &http.Cookie{Name: variantCookie, Value: tt.variant})
+			location := w.Header().Get("Location")
+			if location != "https://example.com/a" && location != "https://example.com/b" {
+				t.Errorf("location = %q, want one of the served variants", location)
+			}
+			if cookie := responseCookie(w, variantCookie); cookie == nil || cookie.Value == tt.variant {
+				t.Errorf("cookie = %v, want it replaced by the picked variant", cookie)
+			}
+		})
+	}
+}
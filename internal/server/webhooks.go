@@ -0,0 +1,239 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/nccapo/url-sh/internal/store"
+	"github.com/nccapo/url-sh/internal/targeting"
+	"github.com/nccapo/url-sh/internal/webhooks"
+)
+
+// WebhookRequest registers a webhook for the short URLs of the owner of the request.
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// ClickThresholds are the redirect counts the link.click_threshold event is sent at.
+	ClickThresholds []int64 `json:"click_thresholds"`
+}
+
+// webhookResponse is a webhook with the key its payloads are signed with.
+type webhookResponse struct {
+	*store.Webhook
+	Secret string `json:"secret"`
+}
+
+// CloseWebhooks queues the webhooks of the clicks still waiting. It must be called
+// once the server stopped serving redirects, the clicks recorded afterwards are lost.
+func CloseWebhooks() {
+	if H.Webhooks != nil {
+		H.Webhooks.Close()
+	}
+}
+
+func (h *Handler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	owner, ok := h.requireOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateWebhook(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := h.Store.Webhooks.Create(r.Context(), &store.Webhook{
+		IID:             uuid.New(),
+		OwnerID:         owner,
+		URL:             req.URL,
+		Events:          req.Events,
+		ClickThresholds: req.ClickThresholds,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, struct {
+		Webhook interface{} `json:"webhook"`
+	}{
+		Webhook: h.webhookResponse(webhook),
+	})
+}
+
+func (h *Handler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	owner, ok := h.requireOwner(w, r)
+	if !ok {
+		return
+	}
+
+	list, err := h.Store.Webhooks.List(r.Context(), owner)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	responses := make([]webhookResponse, len(list))
+	for i := range list {
+		responses[i] = h.webhookResponse(&list[i])
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Webhooks interface{} `json:"webhooks"`
+	}{
+		Webhooks: responses,
+	})
+}
+
+func (h *Handler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	owner, ok := h.requireOwner(w, r)
+	if !ok {
+		return
+	}
+
+	iid, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid webhook id", http.StatusBadRequest)
+		return
+	}
+
+	err = h.Store.Webhooks.Delete(r.Context(), owner, iid)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := h.ownedWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	limit, err := parseLimit(r.URL.Query().Get("limit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	deliveries, err := h.Store.Webhooks.ListDeliveries(r.Context(), webhook.ID, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Deliveries interface{} `json:"deliveries"`
+	}{
+		Deliveries: deliveries,
+	})
+}
+
+func (h *Handler) ReplayDelivery(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := h.ownedWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	iid, err := uuid.Parse(r.PathValue("delivery"))
+	if err != nil {
+		http.Error(w, "invalid delivery id", http.StatusBadRequest)
+		return
+	}
+
+	delivery, err := h.Store.Webhooks.Replay(r.Context(), webhook.ID, iid)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, struct {
+		Delivery interface{} `json:"delivery"`
+	}{
+		Delivery: delivery,
+	})
+}
+
+// ownedWebhook finds the webhook of the id in the path among those of the owner of the request.
+func (h *Handler) ownedWebhook(w http.ResponseWriter, r *http.Request) (*store.Webhook, bool) {
+	owner, ok := h.requireOwner(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	iid, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid webhook id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	webhook, err := h.Store.Webhooks.Find(r.Context(), owner, iid)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return webhook, true
+}
+
+func (h *Handler) webhookResponse(webhook *store.Webhook) webhookResponse {
+	return webhookResponse{Webhook: webhook, Secret: webhooks.SigningKey(h.Config.SecretKey, webhook.IID)}
+}
+
+func validateWebhook(req *WebhookRequest) error {
+	if !targeting.IsHTTPURL(req.URL) {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	// Hosts resolving to a forbidden address are refused when delivering, literal
+	// addresses are refused right away.
+	u, _ := url.Parse(req.URL)
+	host := u.Hostname()
+	if ip, err := netip.ParseAddr(host); strings.EqualFold(host, "localhost") || err == nil && webhooks.IsForbiddenAddress(ip) {
+		return errors.New("url must point to a public address")
+	}
+
+	if len(req.Events) == 0 {
+		return errors.New("events must not be empty")
+	}
+	for _, event := range req.Events {
+		if !webhooks.IsEvent(event) {
+			return fmt.Errorf("unknown event %q", event)
+		}
+	}
+
+	for _, threshold := range req.ClickThresholds {
+		if threshold <= 0 {
+			return errors.New("click_thresholds must be positive")
+		}
+	}
+	if req.ClickThresholds == nil {
+		req.ClickThresholds = []int64{}
+	}
+	return nil
+}
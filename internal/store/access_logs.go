@@ -16,16 +16,89 @@ type AccessLog struct {
 	AccessedAt time.Time `json:"accessed_at"`
 	UserAgent  string    `json:"user_agent"`
 	IPAddress  string    `json:"ip_address"`
+	// IPAnonymized is set when IPAddress was truncated or hashed by the privacy mode.
+	IPAnonymized bool `json:"ip_anonymized"`
+	// Referrer is the normalized referring host and path, empty for direct traffic.
+	Referrer       string `json:"referrer"`
+	ReferrerDomain string `json:"referrer_domain"`
+	// User agent details parsed at ingestion time.
+	BrowserFamily  string `json:"browser_family"`
+	BrowserVersion string `json:"browser_version"`
+	OSFamily       string `json:"os_family"`
+	OSVersion      string `json:"os_version"`
+	DeviceType     string `json:"device_type"`
+	// IsBot marks clicks classified as automated, which analytics exclude by default.
+	IsBot bool `json:"is_bot"`
+	// CountryCode, RegionCode and City are resolved from the IP address, empty if unknown.
+	CountryCode string `json:"country_code"`
+	RegionCode  string `json:"region_code"`
+	City        string `json:"city"`
+	// TargetingRule is the ID of the targeting rule that picked the destination,
+	// empty if the click was sent to the original URL.
+	TargetingRule string `json:"targeting_rule"`
+	// Variant is the ID of the variant the click was sent to, empty if the short
+	// URL has none or a targeting rule matched.
+	Variant string `json:"variant"`
 }
 
 type PostgresAccessLogs struct {
 	db *sql.DB
 }
 
-func (p *PostgresAccessLogs) CreateLog(ctx context.Context, log *AccessLog) error {
-	query := `INSERT INTO access_logs (short_url_id, accessed_at, user_agent, ip_address) VALUES ($1, $2, $3, $4)`
+// Analytics queries, shared with VerifyQueryPlans.
+const (
+	lastAccessedQuery = `SELECT access_logs.id, access_logs.iid, access_logs.short_url_id, access_logs.accessed_at, access_logs.user_agent, access_logs.ip_address,
+	access_logs.referrer, access_logs.referrer_domain, access_logs.browser_family, access_logs.browser_version,
+	access_logs.os_family, access_logs.os_version, access_logs.device_type, access_logs.is_bot,
+	access_logs.country_code, access_logs.region_code, access_logs.city, access_logs.targeting_rule,
+	access_logs.variant FROM access_logs
+	JOIN short_urls ON short_urls.id = access_logs.short_url_id
+	WHERE short_urls.short_code = $1 AND ($2 OR NOT access_logs.is_bot)
+	ORDER BY accessed_at DESC LIMIT 1`
+
+	uniqueIPAddressesQuery = `SELECT access_logs.ip_address FROM access_logs
+	JOIN short_urls ON short_urls.id = access_logs.short_url_id
+	WHERE short_urls.short_code = $1 AND ($2 OR NOT access_logs.is_bot)
+	AND access_logs.accessed_at >= ` + rawClicksSince + `
+	UNION
+	SELECT daily_visitors.ip_address FROM daily_visitors
+	JOIN short_urls ON short_urls.id = daily_visitors.short_url_id
+	WHERE short_urls.short_code = $1 AND ($2 OR NOT daily_visitors.is_bot)
+	AND daily_visitors.day < short_urls.rolled_up_until`
+)
 
-	_, err := p.db.ExecContext(ctx, query, log.ShortURLID, log.AccessedAt, log.UserAgent, log.IPAddress)
+var topUserAgentsQuery = rolledUpCountsQuery("access_logs.user_agent", userAgentDimension, "")
+
+func (p *PostgresAccessLogs) CreateLog(ctx context.Context, log *AccessLog) (err error) {
+	ctx, span := startSpan(ctx, "PostgresAccessLogs.CreateLog", "INSERT", "access_logs")
+	defer func() { endSpan(span, err) }()
+
+	query := `INSERT INTO access_logs (
+		short_url_id, accessed_at, user_agent, ip_address, referrer, referrer_domain,
+		browser_family, browser_version, os_family, os_version, device_type, is_bot,
+		country_code, region_code, city, ip_anonymized, targeting_rule, variant
+	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)`
+
+	_, err = p.db.ExecContext(ctx, query,
+		log.ShortURLID,
+		log.AccessedAt,
+		log.UserAgent,
+		log.IPAddress,
+		log.Referrer,
+		log.ReferrerDomain,
+		log.BrowserFamily,
+		log.BrowserVersion,
+		log.OSFamily,
+		log.OSVersion,
+		log.DeviceType,
+		log.IsBot,
+		log.CountryCode,
+		log.RegionCode,
+		log.City,
+		log.IPAnonymized,
+		log.TargetingRule,
+		log.Variant,
+	)
 	if err != nil {
 		return err
 	}
@@ -33,16 +106,16 @@ func (p *PostgresAccessLogs) CreateLog(ctx context.Context, log *AccessLog) erro
 	return nil
 }
 
-func (p *PostgresAccessLogs) LastAccessed(ctx context.Context, shortCode string) (*AccessLog, error) {
-	var log AccessLog
+func (p *PostgresAccessLogs) LastAccessed(ctx context.Context, shortCode string, includeBots bool) (_ *AccessLog, err error) {
+	ctx, span := startSpan(ctx, "PostgresAccessLogs.LastAccessed", "SELECT", "access_logs")
+	defer func() { endSpan(span, err) }()
 
-	query := `SELECT access_logs.id, access_logs.iid, access_logs.short_url_id, access_logs.accessed_at, access_logs.user_agent, access_logs.ip_address FROM access_logs
-	JOIN short_urls ON short_urls.id = access_logs.short_url_id
-	WHERE short_urls.short_code = $1
-	ORDER BY accessed_at DESC LIMIT 1`
+	var log AccessLog
 
-	err := p.db.QueryRowContext(ctx, query, shortCode).
-		Scan(&log.ID, &log.IID, &log.ShortURLID, &log.AccessedAt, &log.UserAgent, &log.IPAddress)
+	err = p.db.QueryRowContext(ctx, lastAccessedQuery, shortCode, includeBots).
+		Scan(&log.ID, &log.IID, &log.ShortURLID, &log.AccessedAt, &log.UserAgent, &log.IPAddress, &log.Referrer, &log.ReferrerDomain,
+			&log.BrowserFamily, &log.BrowserVersion, &log.OSFamily, &log.OSVersion, &log.DeviceType, &log.IsBot,
+			&log.CountryCode, &log.RegionCode, &log.City, &log.TargetingRule, &log.Variant)
 	if err != nil {
 		return nil, err
 	}
@@ -50,12 +123,11 @@ func (p *PostgresAccessLogs) LastAccessed(ctx context.Context, shortCode string)
 	return &log, nil
 }
 
-func (p *PostgresAccessLogs) UniqueIPAddresses(ctx context.Context, shortCode string) ([]string, error) {
-	query := `SELECT DISTINCT ip_address FROM access_logs
-	JOIN short_urls ON short_urls.id = access_logs.short_url_id
-	WHERE short_urls.short_code = $1`
+func (p *PostgresAccessLogs) UniqueIPAddresses(ctx context.Context, shortCode string, includeBots bool) (_ []string, err error) {
+	ctx, span := startSpan(ctx, "PostgresAccessLogs.UniqueIPAddresses", "SELECT", "access_logs")
+	defer func() { endSpan(span, err) }()
 
-	rows, err := p.db.QueryContext(ctx, query, shortCode)
+	rows, err := p.db.QueryContext(ctx, uniqueIPAddressesQuery, shortCode, includeBots)
 	if err != nil {
 		return nil, err
 	}
@@ -73,14 +145,11 @@ func (p *PostgresAccessLogs) UniqueIPAddresses(ctx context.Context, shortCode st
 	return ipAddresses, nil
 }
 
-func (p *PostgresAccessLogs) TopUserAgents(ctx context.Context, shortCode string) ([]string, error) {
-	query := `SELECT user_agent FROM access_logs
-	JOIN short_urls ON short_urls.id = access_logs.short_url_id
-	WHERE short_urls.short_code = $1
-	GROUP BY user_agent
-	ORDER BY COUNT(*) DESC LIMIT $2`
+func (p *PostgresAccessLogs) TopUserAgents(ctx context.Context, shortCode string, includeBots bool) (_ []string, err error) {
+	ctx, span := startSpan(ctx, "PostgresAccessLogs.TopUserAgents", "SELECT", "access_logs")
+	defer func() { endSpan(span, err) }()
 
-	rows, err := p.db.QueryContext(ctx, query, shortCode, 5)
+	rows, err := p.db.QueryContext(ctx, topUserAgentsQuery, shortCode, 5, includeBots)
 	if err != nil {
 		return nil, err
 	}
@@ -89,7 +158,8 @@ func (p *PostgresAccessLogs) TopUserAgents(ctx context.Context, shortCode string
 	var userAgents []string
 	for rows.Next() {
 		var userAgent string
-		if err := rows.Scan(&userAgent); err != nil {
+		var clicks int64
+		if err := rows.Scan(&userAgent, &clicks); err != nil {
 			return nil, err
 		}
 		userAgents = append(userAgents, userAgent)
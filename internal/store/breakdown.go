@@ -0,0 +1,85 @@
+package store
+
+import (
+	"context"
+	"fmt"
+)
+
+// Dimensions clicks can be broken down by.
+const (
+	DimensionBrowser        = "browser"
+	DimensionBrowserVersion = "browser_version"
+	DimensionOS             = "os"
+	DimensionOSVersion      = "os_version"
+	DimensionDevice         = "device"
+	DimensionCountry        = "country"
+	// DimensionRegion values are ISO 3166-2 codes such as "US-CA".
+	DimensionRegion = "region"
+	// DimensionCity values are the city followed by its country code, e.g. "Berlin, DE".
+	DimensionCity = "city"
+	// DimensionRule values are the IDs of the targeting rules that picked the destination.
+	DimensionRule = "rule"
+	// DimensionVariant values are the IDs of the variants the clicks were sent to.
+	DimensionVariant = "variant"
+)
+
+// UnknownDimension is the bucket of clicks whose dimension value couldn't be determined.
+const UnknownDimension = "(unknown)"
+
+// DimensionCount is the number of clicks sharing one value of a dimension.
+type DimensionCount struct {
+	Value  string `json:"value"`
+	Clicks int64  `json:"clicks"`
+}
+
+// dimensionExpressions maps a dimension to the access_logs expression it groups by.
+var dimensionExpressions = map[string]string{
+	DimensionBrowser:        "access_logs.browser_family",
+	DimensionBrowserVersion: "concat_ws(' ', NULLIF(access_logs.browser_family, ''), NULLIF(access_logs.browser_version, ''))",
+	DimensionOS:             "access_logs.os_family",
+	DimensionOSVersion:      "concat_ws(' ', NULLIF(access_logs.os_family, ''), NULLIF(access_logs.os_version, ''))",
+	DimensionDevice:         "access_logs.device_type",
+	DimensionCountry:        "access_logs.country_code",
+	DimensionRegion:         "CASE WHEN access_logs.region_code = '' THEN '' ELSE concat_ws('-', NULLIF(access_logs.country_code, ''), access_logs.region_code) END",
+	DimensionCity:           "CASE WHEN access_logs.city = '' THEN '' ELSE concat_ws(', ', access_logs.city, NULLIF(access_logs.country_code, '')) END",
+	DimensionRule:           "access_logs.targeting_rule",
+	DimensionVariant:        "access_logs.variant",
+}
+
+// IsDimension reports whether clicks can be broken down by dimension.
+func IsDimension(dimension string) bool {
+	_, ok := dimensionExpressions[dimension]
+	return ok
+}
+
+func breakdownQuery(dimension string) string {
+	return rolledUpCountsQuery(dimensionExpressions[dimension], dimension, UnknownDimension)
+}
+
+// Breakdown returns the click counts of a short URL per value of dimension, most clicked first.
+// Bot clicks are only counted if includeBots is set.
+func (p *PostgresAccessLogs) Breakdown(ctx context.Context, shortCode string, dimension string, limit int, includeBots bool) (_ []DimensionCount, err error) {
+	ctx, span := startSpan(ctx, "PostgresAccessLogs.Breakdown", "SELECT", "access_logs")
+	defer func() { endSpan(span, err) }()
+
+	if !IsDimension(dimension) {
+		return nil, fmt.Errorf("unknown dimension %q", dimension)
+	}
+
+	rows, err := p.db.QueryContext(ctx, breakdownQuery(dimension), shortCode, limit, includeBots)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []DimensionCount{}
+	for rows.Next() {
+		var count DimensionCount
+		if err := rows.Scan(&count.Value, &count.Clicks); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+
+	return counts, rows.Err()
+}
@@ -0,0 +1,176 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// TrackingParams are query parameters added to the destination of a short URL
+// next to its UTM parameters, such as gclid or ref.
+type TrackingParams map[string]string
+
+// Value stores the parameters as a JSON object.
+func (params TrackingParams) Value() (driver.Value, error) {
+	if params == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(params)
+	return string(data), err
+}
+
+// Scan reads parameters stored as a JSON object.
+func (params *TrackingParams) Scan(src any) error {
+	switch src := src.(type) {
+	case []byte:
+		return json.Unmarshal(src, params)
+	case string:
+		return json.Unmarshal([]byte(src), params)
+	case nil:
+		*params = nil
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into tracking params", src)
+}
+
+// Campaign is a named set of UTM and tracking parameters an owner applies to many
+// short URLs. Applying it copies its parameters into the short URL, later changes
+// to the campaign don't affect the short URLs it was applied to.
+type Campaign struct {
+	ID      int64     `json:"-"`
+	IID     uuid.UUID `json:"id"`
+	OwnerID string    `json:"-"`
+	Name    string    `json:"name"`
+	// UTM Parameters
+	UTMSource      string         `json:"utm_source,omitempty"`
+	UTMMedium      string         `json:"utm_medium,omitempty"`
+	UTMCampaign    string         `json:"utm_campaign,omitempty"`
+	UTMTerm        string         `json:"utm_term,omitempty"`
+	UTMContent     string         `json:"utm_content,omitempty"`
+	TrackingParams TrackingParams `json:"tracking_params"`
+	CreatedAt      time.Time      `json:"created_at"`
+}
+
+type PostgresCampaigns struct {
+	db *sql.DB
+}
+
+const campaignColumns = `id, iid, owner_id, name, utm_source, utm_medium, utm_campaign,
+	utm_term, utm_content, tracking_params, created_at`
+
+func scanCampaign(row rowScanner) (*Campaign, error) {
+	var campaign Campaign
+	err := row.Scan(
+		&campaign.ID,
+		&campaign.IID,
+		&campaign.OwnerID,
+		&campaign.Name,
+		&campaign.UTMSource,
+		&campaign.UTMMedium,
+		&campaign.UTMCampaign,
+		&campaign.UTMTerm,
+		&campaign.UTMContent,
+		&campaign.TrackingParams,
+		&campaign.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if campaign.TrackingParams == nil {
+		campaign.TrackingParams = TrackingParams{}
+	}
+	return &campaign, nil
+}
+
+// Create saves a campaign, ErrDuplicateCampaign is returned if the owner already
+// has one with the same name.
+func (p *PostgresCampaigns) Create(ctx context.Context, campaign *Campaign) (_ *Campaign, err error) {
+	ctx, span := startSpan(ctx, "PostgresCampaigns.Create", "INSERT", "campaigns")
+	defer func() { endSpan(span, err) }()
+
+	query := `INSERT INTO campaigns (owner_id, name, utm_source, utm_medium, utm_campaign,
+		utm_term, utm_content, tracking_params)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING ` + campaignColumns
+
+	created, err := scanCampaign(p.db.QueryRowContext(ctx, query,
+		campaign.OwnerID,
+		campaign.Name,
+		campaign.UTMSource,
+		campaign.UTMMedium,
+		campaign.UTMCampaign,
+		campaign.UTMTerm,
+		campaign.UTMContent,
+		campaign.TrackingParams,
+	))
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return nil, ErrDuplicateCampaign
+	}
+	return created, err
+}
+
+// List returns the campaigns of an owner, sorted by name.
+func (p *PostgresCampaigns) List(ctx context.Context, ownerID string) (_ []Campaign, err error) {
+	ctx, span := startSpan(ctx, "PostgresCampaigns.List", "SELECT", "campaigns")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT ` + campaignColumns + ` FROM campaigns WHERE owner_id = $1 ORDER BY name`
+
+	rows, err := p.db.QueryContext(ctx, query, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	campaigns := []Campaign{}
+	for rows.Next() {
+		campaign, err := scanCampaign(rows)
+		if err != nil {
+			return nil, err
+		}
+		campaigns = append(campaigns, *campaign)
+	}
+
+	return campaigns, rows.Err()
+}
+
+// Find returns the campaign of an owner with the given name.
+func (p *PostgresCampaigns) Find(ctx context.Context, ownerID, name string) (_ *Campaign, err error) {
+	ctx, span := startSpan(ctx, "PostgresCampaigns.Find", "SELECT", "campaigns")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT ` + campaignColumns + ` FROM campaigns WHERE owner_id = $1 AND name = $2`
+
+	return scanCampaign(p.db.QueryRowContext(ctx, query, ownerID, name))
+}
+
+// Delete removes the campaign of an owner with the given name. The short URLs it was
+// applied to keep their parameters.
+func (p *PostgresCampaigns) Delete(ctx context.Context, ownerID, name string) (err error) {
+	ctx, span := startSpan(ctx, "PostgresCampaigns.Delete", "DELETE", "campaigns")
+	defer func() { endSpan(span, err) }()
+
+	result, err := p.db.ExecContext(ctx, `DELETE FROM campaigns WHERE owner_id = $1 AND name = $2`, ownerID, name)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
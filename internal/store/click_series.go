@@ -0,0 +1,202 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Intervals a click time series can be bucketed by.
+const (
+	IntervalMinute = "minute"
+	IntervalHour   = "hour"
+	IntervalDay    = "day"
+	IntervalWeek   = "week"
+)
+
+// IntervalDuration returns the nominal length of an interval, or false if it is unknown.
+// Days and weeks are assumed to be 24 and 168 hours long.
+func IntervalDuration(interval string) (time.Duration, bool) {
+	switch interval {
+	case IntervalMinute:
+		return time.Minute, true
+	case IntervalHour:
+		return time.Hour, true
+	case IntervalDay:
+		return 24 * time.Hour, true
+	case IntervalWeek:
+		return 7 * 24 * time.Hour, true
+	}
+	return 0, false
+}
+
+// ClickSeriesQuery selects the range and bucketing of a click time series.
+type ClickSeriesQuery struct {
+	// Interval is one of IntervalMinute, IntervalHour, IntervalDay or IntervalWeek.
+	Interval string
+	// From is the inclusive start of the range.
+	From time.Time
+	// To is the exclusive end of the range.
+	To time.Time
+	// Location is the time zone buckets are aligned to.
+	Location *time.Location
+	// IncludeBots counts clicks classified as automated.
+	IncludeBots bool
+}
+
+// ClickBucket holds the clicks recorded within one bucket of a time series.
+type ClickBucket struct {
+	Start          time.Time `json:"start"`
+	Clicks         int64     `json:"clicks"`
+	UniqueVisitors int64     `json:"unique_visitors"`
+}
+
+// ClickSeries is the click time series of a short URL.
+type ClickSeries struct {
+	Interval       string        `json:"interval"`
+	Timezone       string        `json:"timezone"`
+	From           time.Time     `json:"from"`
+	To             time.Time     `json:"to"`
+	TotalClicks    int64         `json:"total_clicks"`
+	UniqueVisitors int64         `json:"unique_visitors"`
+	Buckets        []ClickBucket `json:"buckets"`
+}
+
+// clickSeriesQuery returns one row per bucket between $3 and $4, including empty ones.
+// Buckets are truncated in the wall clock time of the $5 time zone.
+//
+// Rolled up days are counted in the bucket their UTC start falls in. Their visitors
+// are counted once with the visitors of the access logs, whose IP addresses they keep.
+var clickSeriesQuery = `WITH buckets AS (
+		SELECT generate_series(
+			date_trunc($2::text, $3::timestamptz AT TIME ZONE $5::text),
+			date_trunc($2::text, ($4::timestamptz - interval '1 microsecond') AT TIME ZONE $5::text),
+			('1 ' || $2::text)::interval
+		) AS bucket
+	),
+	raw AS (
+		SELECT date_trunc($2::text, access_logs.accessed_at AT TIME ZONE $5::text) AS bucket,
+			COUNT(*) AS clicks
+		FROM access_logs
+		JOIN short_urls ON short_urls.id = access_logs.short_url_id
+		WHERE short_urls.short_code = $1
+		AND access_logs.accessed_at >= $3 AND access_logs.accessed_at < $4
+		AND access_logs.accessed_at >= ` + rawClicksSince + `
+		AND ($6 OR NOT access_logs.is_bot)
+		GROUP BY 1
+	),
+	daily AS (
+		SELECT date_trunc($2::text, (daily_clicks.day::timestamp AT TIME ZONE 'UTC') AT TIME ZONE $5::text) AS bucket,
+			SUM(daily_clicks.clicks) AS clicks
+		FROM daily_clicks
+		JOIN short_urls ON short_urls.id = daily_clicks.short_url_id
+		WHERE short_urls.short_code = $1
+		AND daily_clicks.day::timestamp AT TIME ZONE 'UTC' >= $3 AND daily_clicks.day::timestamp AT TIME ZONE 'UTC' < $4
+		AND daily_clicks.day < short_urls.rolled_up_until
+		AND ($6 OR NOT daily_clicks.is_bot)
+		GROUP BY 1
+	),
+	visitors AS (
+		SELECT combined.bucket, COUNT(DISTINCT combined.ip_address) AS unique_visitors
+		FROM (` + visitorsQuery("date_trunc($2::text, access_logs.accessed_at AT TIME ZONE $5::text)",
+	"date_trunc($2::text, (daily_visitors.day::timestamp AT TIME ZONE 'UTC') AT TIME ZONE $5::text)", "$3", "$4", "$6") + `
+		) AS combined
+		GROUP BY 1
+	)
+	SELECT buckets.bucket AT TIME ZONE $5::text,
+		(COALESCE(raw.clicks, 0) + COALESCE(daily.clicks, 0))::bigint,
+		COALESCE(visitors.unique_visitors, 0)::bigint
+	FROM buckets
+	LEFT JOIN raw ON raw.bucket = buckets.bucket
+	LEFT JOIN daily ON daily.bucket = buckets.bucket
+	LEFT JOIN visitors ON visitors.bucket = buckets.bucket
+	ORDER BY buckets.bucket`
+
+// clickTotalsQuery returns the clicks and unique visitors between $2 and $3.
+var clickTotalsQuery = `WITH raw AS (
+		SELECT COUNT(*) AS clicks
+		FROM access_logs
+		JOIN short_urls ON short_urls.id = access_logs.short_url_id
+		WHERE short_urls.short_code = $1
+		AND access_logs.accessed_at >= $2 AND access_logs.accessed_at < $3
+		AND access_logs.accessed_at >= ` + rawClicksSince + `
+		AND ($4 OR NOT access_logs.is_bot)
+	),
+	daily AS (
+		SELECT COALESCE(SUM(daily_clicks.clicks), 0) AS clicks
+		FROM daily_clicks
+		JOIN short_urls ON short_urls.id = daily_clicks.short_url_id
+		WHERE short_urls.short_code = $1
+		AND daily_clicks.day::timestamp AT TIME ZONE 'UTC' >= $2 AND daily_clicks.day::timestamp AT TIME ZONE 'UTC' < $3
+		AND daily_clicks.day < short_urls.rolled_up_until
+		AND ($4 OR NOT daily_clicks.is_bot)
+	),
+	visitors AS (
+		SELECT COUNT(DISTINCT combined.ip_address) AS unique_visitors
+		FROM (` + visitorsQuery("NULL", "NULL", "$2", "$3", "$4") + `
+		) AS combined
+	)
+	SELECT (raw.clicks + daily.clicks)::bigint, visitors.unique_visitors::bigint
+	FROM raw, daily, visitors`
+
+// visitorsQuery selects the IP addresses of the visitors of short URL $1 between from
+// and to, from the access logs and the rolled up visitors, together with the bucket
+// computed by rawBucket and dailyBucket respectively.
+func visitorsQuery(rawBucket, dailyBucket, from, to, includeBots string) string {
+	return `
+		SELECT ` + rawBucket + ` AS bucket, access_logs.ip_address
+		FROM access_logs
+		JOIN short_urls ON short_urls.id = access_logs.short_url_id
+		WHERE short_urls.short_code = $1
+		AND access_logs.accessed_at >= ` + from + ` AND access_logs.accessed_at < ` + to + `
+		AND access_logs.accessed_at >= ` + rawClicksSince + `
+		AND (` + includeBots + ` OR NOT access_logs.is_bot)
+		UNION ALL
+		SELECT ` + dailyBucket + `, daily_visitors.ip_address
+		FROM daily_visitors
+		JOIN short_urls ON short_urls.id = daily_visitors.short_url_id
+		WHERE short_urls.short_code = $1
+		AND daily_visitors.day::timestamp AT TIME ZONE 'UTC' >= ` + from + ` AND daily_visitors.day::timestamp AT TIME ZONE 'UTC' < ` + to + `
+		AND daily_visitors.day < short_urls.rolled_up_until
+		AND (` + includeBots + ` OR NOT daily_visitors.is_bot)`
+}
+
+// ClickSeries returns the clicks of a short URL over the query range, bucketed by
+// the query interval, together with the totals over the whole range.
+func (p *PostgresAccessLogs) ClickSeries(ctx context.Context, shortCode string, q ClickSeriesQuery) (_ *ClickSeries, err error) {
+	ctx, span := startSpan(ctx, "PostgresAccessLogs.ClickSeries", "SELECT", "access_logs")
+	defer func() { endSpan(span, err) }()
+
+	series := ClickSeries{
+		Interval: q.Interval,
+		Timezone: q.Location.String(),
+		From:     q.From,
+		To:       q.To,
+		Buckets:  []ClickBucket{},
+	}
+
+	rows, err := p.db.QueryContext(ctx, clickSeriesQuery, shortCode, q.Interval, q.From, q.To, series.Timezone, q.IncludeBots)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bucket ClickBucket
+		if err := rows.Scan(&bucket.Start, &bucket.Clicks, &bucket.UniqueVisitors); err != nil {
+			return nil, err
+		}
+		bucket.Start = bucket.Start.In(q.Location)
+		series.Buckets = append(series.Buckets, bucket)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = p.db.QueryRowContext(ctx, clickTotalsQuery, shortCode, q.From, q.To, q.IncludeBots).
+		Scan(&series.TotalClicks, &series.UniqueVisitors)
+	if err != nil {
+		return nil, err
+	}
+
+	return &series, nil
+}
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// plannedQuery is an analytics query together with the index its plan must use.
+type plannedQuery struct {
+	name  string
+	query string
+	args  []any
+	index string
+}
+
+// plannedQueries are the access log queries whose plans are checked by VerifyQueryPlans.
+var plannedQueries = []plannedQuery{
+	{"LastAccessed", lastAccessedQuery, []any{"", false}, "access_logs_short_url_id_accessed_at_idx"},
+	{"UniqueIPAddresses", uniqueIPAddressesQuery, []any{"", false}, "access_logs_short_url_id_accessed_at_idx"},
+	{"TopUserAgents", topUserAgentsQuery, []any{"", 5, false}, "access_logs_short_url_id_accessed_at_idx"},
+	{"ClickSeries", clickSeriesQuery, []any{"", IntervalDay, time.Unix(0, 0), time.Unix(86400, 0), "UTC", false}, "access_logs_short_url_id_accessed_at_idx"},
+	{"TopReferrers", topReferrersQuery(referrerColumns[ReferrerByDomain]), []any{"", 10, false}, "access_logs_short_url_id_accessed_at_idx"},
+	{"Breakdown", breakdownQuery(DimensionBrowser), []any{"", 10, false}, "access_logs_short_url_id_accessed_at_idx"},
+	{"ClickSeries totals", clickTotalsQuery, []any{"", time.Unix(0, 0), time.Unix(86400, 0), false}, "access_logs_short_url_id_accessed_at_idx"},
+}
+
+// VerifyQueryPlans explains the analytics queries against db and returns an error
+// naming every query whose plan does not use the index it relies on.
+func VerifyQueryPlans(ctx context.Context, db *sql.DB) error {
+	tx, err := beginPlanCheck(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var errs []error
+	for _, q := range plannedQueries {
+		plan, err := q.explain(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if !q.usesIndex(plan) {
+			errs = append(errs, fmt.Errorf("%s does not use index %s:\n%s", q.name, q.index, plan))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// beginPlanCheck starts the read-only transaction queries are explained in.
+//
+// Sequential scans are disabled for the check so that the result doesn't depend on
+// the table sizes: a query that can't use the index still falls back to one.
+func beginPlanCheck(ctx context.Context, db *sql.DB) (*sql.Tx, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `SET LOCAL enable_seqscan = off`); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+// explain returns the JSON plan of the query in tx.
+func (q plannedQuery) explain(ctx context.Context, tx *sql.Tx) (string, error) {
+	var plan string
+	if err := tx.QueryRowContext(ctx, `EXPLAIN (FORMAT JSON) `+q.query, q.args...).Scan(&plan); err != nil {
+		return "", fmt.Errorf("explain %s: %w", q.name, err)
+	}
+	return plan, nil
+}
+
+// usesIndex reports whether plan scans the index the query relies on.
+func (q plannedQuery) usesIndex(plan string) bool {
+	return strings.Contains(plan, `"Index Name": "`+q.index+`"`)
+}
@@ -0,0 +1,48 @@
+package store
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nccapo/url-sh/internal/db"
+)
+
+// testDBAddressEnv names the variable holding the DSN of a migrated database the
+// query plans are checked against. The tests are skipped when it is unset.
+const testDBAddressEnv = "TEST_DB_ADDRESS"
+
+func TestAccessLogQueriesUseIndexes(t *testing.T) {
+	addr := os.Getenv(testDBAddressEnv)
+	if addr == "" {
+		t.Skipf("%s is not set", testDBAddressEnv)
+	}
+
+	conn, err := db.NewConn(addr, 1, 1, time.Minute)
+	if err != nil {
+		t.Fatalf("connect to database: %v", err)
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+	for _, q := range plannedQueries {
+		t.Run(q.name, func(t *testing.T) {
+			// Every query is explained in its own transaction, so that a failing one
+			// doesn't abort the others.
+			tx, err := beginPlanCheck(ctx, conn)
+			if err != nil {
+				t.Fatalf("begin: %v", err)
+			}
+			defer tx.Rollback()
+
+			plan, err := q.explain(ctx, tx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !q.usesIndex(plan) {
+				t.Errorf("plan does not use index %s:\n%s", q.index, plan)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// saltSize is the size in bytes of the daily IP hashing salts.
+const saltSize = 32
+
+type PostgresPrivacy struct {
+	db *sql.DB
+}
+
+// DailySalt returns the salt of day, generating it on first use. Salts of earlier days
+// are deleted, so that their hashes can no longer be linked back to IP addresses.
+func (p *PostgresPrivacy) DailySalt(ctx context.Context, day time.Time) (_ []byte, err error) {
+	ctx, span := startSpan(ctx, "PostgresPrivacy.DailySalt", "INSERT", "privacy_salts")
+	defer func() { endSpan(span, err) }()
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	// Concurrent callers race on the insert, all of them read the winning salt.
+	query := `WITH inserted AS (
+		INSERT INTO privacy_salts (day, salt) VALUES ($1, $2)
+		ON CONFLICT (day) DO NOTHING
+		RETURNING salt
+	)
+	SELECT salt FROM inserted
+	UNION ALL
+	SELECT salt FROM privacy_salts WHERE day = $1
+	LIMIT 1`
+
+	err = p.db.QueryRowContext(ctx, query, day, salt).Scan(&salt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = p.db.ExecContext(ctx, `DELETE FROM privacy_salts WHERE day < $1`, day)
+	if err != nil {
+		return nil, err
+	}
+
+	return salt, nil
+}
+
+// PendingAnonymization returns up to limit access logs with an ID greater than afterID
+// whose IP address hasn't been anonymized yet, in ID order. Only the ID, access time
+// and IP address are set.
+func (p *PostgresPrivacy) PendingAnonymization(ctx context.Context, afterID int64, limit int) (_ []AccessLog, err error) {
+	ctx, span := startSpan(ctx, "PostgresPrivacy.PendingAnonymization", "SELECT", "access_logs")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT id, accessed_at, ip_address FROM access_logs
+	WHERE id > $1 AND NOT ip_anonymized
+	ORDER BY id LIMIT $2`
+
+	rows, err := p.db.QueryContext(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var logs []AccessLog
+	for rows.Next() {
+		var log AccessLog
+		if err := rows.Scan(&log.ID, &log.AccessedAt, &log.IPAddress); err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+
+	return logs, rows.Err()
+}
+
+// AnonymizeIPs replaces the IP address of each access log in ids with the address at
+// the same index of ipAddresses and marks it as anonymized.
+func (p *PostgresPrivacy) AnonymizeIPs(ctx context.Context, ids []int64, ipAddresses []string) (err error) {
+	ctx, span := startSpan(ctx, "PostgresPrivacy.AnonymizeIPs", "UPDATE", "access_logs")
+	defer func() { endSpan(span, err) }()
+
+	query := `UPDATE access_logs SET ip_address = anonymized.ip_address, ip_anonymized = true
+	FROM unnest($1::bigint[], $2::text[]) AS anonymized (id, ip_address)
+	WHERE access_logs.id = anonymized.id`
+
+	_, err = p.db.ExecContext(ctx, query, pq.Array(ids), pq.Array(ipAddresses))
+	return err
+}
+
+// Visitor is a distinct visitor of a short URL on a UTC day, kept once the access
+// logs of the day are rolled up.
+type Visitor struct {
+	ShortURLID int64
+	Day        time.Time
+	IsBot      bool
+	IPAddress  string
+}
+
+// PendingVisitorAnonymization returns up to limit rolled up visitors whose IP address
+// hasn't been anonymized yet.
+func (p *PostgresPrivacy) PendingVisitorAnonymization(ctx context.Context, limit int) (_ []Visitor, err error) {
+	ctx, span := startSpan(ctx, "PostgresPrivacy.PendingVisitorAnonymization", "SELECT", "daily_visitors")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT short_url_id, day, is_bot, ip_address FROM daily_visitors
+	WHERE NOT ip_anonymized
+	LIMIT $1`
+
+	rows, err := p.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var visitors []Visitor
+	for rows.Next() {
+		var visitor Visitor
+		if err := rows.Scan(&visitor.ShortURLID, &visitor.Day, &visitor.IsBot, &visitor.IPAddress); err != nil {
+			return nil, err
+		}
+		visitors = append(visitors, visitor)
+	}
+
+	return visitors, rows.Err()
+}
+
+// AnonymizeVisitors replaces the IP address of each visitor with the address at the
+// same index of ipAddresses and marks it as anonymized. Visitors whose anonymized
+// addresses are the same on a day are merged.
+func (p *PostgresPrivacy) AnonymizeVisitors(ctx context.Context, visitors []Visitor, ipAddresses []string) (err error) {
+	ctx, span := startSpan(ctx, "PostgresPrivacy.AnonymizeVisitors", "UPDATE", "daily_visitors")
+	defer func() { endSpan(span, err) }()
+
+	ids := make([]int64, len(visitors))
+	days := make([]string, len(visitors))
+	bots := make([]bool, len(visitors))
+	raw := make([]string, len(visitors))
+	for i, visitor := range visitors {
+		ids[i] = visitor.ShortURLID
+		days[i] = visitor.Day.Format(time.DateOnly)
+		bots[i] = visitor.IsBot
+		raw[i] = visitor.IPAddress
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The visitors are deleted and inserted again, rather than updated, since their
+	// anonymized addresses may collide with each other.
+	_, err = tx.ExecContext(ctx, `DELETE FROM daily_visitors
+	USING unnest($1::bigint[], $2::date[], $3::boolean[], $4::text[]) AS visitors (short_url_id, day, is_bot, ip_address)
+	WHERE daily_visitors.short_url_id = visitors.short_url_id AND daily_visitors.day = visitors.day
+	AND daily_visitors.is_bot = visitors.is_bot AND daily_visitors.ip_address = visitors.ip_address
+	AND NOT daily_visitors.ip_anonymized`, pq.Array(ids), pq.Array(days), pq.Array(bots), pq.Array(raw))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO daily_visitors (short_url_id, day, is_bot, ip_address, ip_anonymized)
+	SELECT DISTINCT short_url_id, day, is_bot, ip_address, true
+	FROM unnest($1::bigint[], $2::date[], $3::boolean[], $4::text[]) AS visitors (short_url_id, day, is_bot, ip_address)
+	ON CONFLICT (short_url_id, day, is_bot, ip_address) DO UPDATE SET ip_anonymized = true`,
+		pq.Array(ids), pq.Array(days), pq.Array(bots), pq.Array(ipAddresses))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
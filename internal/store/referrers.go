@@ -0,0 +1,63 @@
+package store
+
+import (
+	"context"
+	"fmt"
+)
+
+// Groupings supported by TopReferrers.
+const (
+	// ReferrerByDomain groups referrers by their domain.
+	ReferrerByDomain = "domain"
+	// ReferrerByURL groups referrers by their normalized host and path.
+	ReferrerByURL = "url"
+)
+
+// DirectReferrer is the bucket of clicks that carried no referrer.
+const DirectReferrer = "(direct)"
+
+// ReferrerCount is the number of clicks coming from one referrer.
+type ReferrerCount struct {
+	Referrer string `json:"referrer"`
+	Clicks   int64  `json:"clicks"`
+}
+
+// referrerColumns maps a grouping to the access_logs column it groups by.
+var referrerColumns = map[string]string{
+	ReferrerByDomain: "referrer_domain",
+	ReferrerByURL:    "referrer",
+}
+
+func topReferrersQuery(column string) string {
+	return rolledUpCountsQuery("access_logs."+column, column, DirectReferrer)
+}
+
+// TopReferrers returns the referrers that brought the most clicks to a short URL,
+// grouped by ReferrerByDomain or ReferrerByURL. Clicks without referrer are
+// counted under DirectReferrer. Bot clicks are only counted if includeBots is set.
+func (p *PostgresAccessLogs) TopReferrers(ctx context.Context, shortCode string, groupBy string, limit int, includeBots bool) (_ []ReferrerCount, err error) {
+	ctx, span := startSpan(ctx, "PostgresAccessLogs.TopReferrers", "SELECT", "access_logs")
+	defer func() { endSpan(span, err) }()
+
+	column, ok := referrerColumns[groupBy]
+	if !ok {
+		return nil, fmt.Errorf("unknown referrer grouping %q", groupBy)
+	}
+
+	rows, err := p.db.QueryContext(ctx, topReferrersQuery(column), shortCode, limit, includeBots)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	referrers := []ReferrerCount{}
+	for rows.Next() {
+		var referrer ReferrerCount
+		if err := rows.Scan(&referrer.Referrer, &referrer.Clicks); err != nil {
+			return nil, err
+		}
+		referrers = append(referrers, referrer)
+	}
+
+	return referrers, rows.Err()
+}
@@ -0,0 +1,298 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// rollupLockClass namespaces the advisory locks taken while rolling up a short URL.
+const rollupLockClass = 726153
+
+// rawClicksSince is the start of the clicks of a short URL served from access_logs.
+// Earlier clicks are served from daily_clicks and daily_click_dimensions.
+const rawClicksSince = `COALESCE(short_urls.rolled_up_until::timestamp AT TIME ZONE 'UTC', '-infinity')`
+
+// userAgentDimension is the rollup dimension of the raw User-Agent header.
+const userAgentDimension = "user_agent"
+
+// rollupDimensions maps the dimensions kept in daily_click_dimensions to the
+// access_logs expression they are computed from.
+var rollupDimensions = func() map[string]string {
+	dimensions := map[string]string{}
+	for dimension, expression := range dimensionExpressions {
+		dimensions[dimension] = expression
+	}
+	for _, column := range referrerColumns {
+		dimensions[column] = "access_logs." + column
+	}
+	dimensions[userAgentDimension] = "access_logs.user_agent"
+	return dimensions
+}()
+
+// rolledUpCountsQuery counts the clicks of a short URL per value of expression, over
+// the clicks in access_logs and the rollups of dimension. Empty values are counted
+// under label. $1 is the short code, $2 the limit and $3 whether bots are included.
+func rolledUpCountsQuery(expression, dimension, label string) string {
+	return `SELECT COALESCE(NULLIF(combined.value, ''), '` + label + `') AS value, SUM(combined.clicks)::bigint AS clicks
+	FROM (
+		SELECT ` + expression + ` AS value, COUNT(*) AS clicks
+		FROM access_logs
+		JOIN short_urls ON short_urls.id = access_logs.short_url_id
+		WHERE short_urls.short_code = $1 AND ($3 OR NOT access_logs.is_bot)
+		AND access_logs.accessed_at >= ` + rawClicksSince + `
+		GROUP BY 1
+		UNION ALL
+		SELECT daily_click_dimensions.value, SUM(daily_click_dimensions.clicks)
+		FROM daily_click_dimensions
+		JOIN short_urls ON short_urls.id = daily_click_dimensions.short_url_id
+		WHERE short_urls.short_code = $1 AND ($3 OR NOT daily_click_dimensions.is_bot)
+		AND daily_click_dimensions.dimension = '` + dimension + `'
+		AND daily_click_dimensions.day < short_urls.rolled_up_until
+		GROUP BY 1
+	) AS combined
+	GROUP BY 1
+	ORDER BY clicks DESC, value LIMIT $2`
+}
+
+// rollupDimensionsQuery aggregates the clicks of short URL $1 between $2 and $3 into
+// daily_click_dimensions, one row per day, bot flag, dimension and value.
+var rollupDimensionsQuery = func() string {
+	names := make([]string, 0, len(rollupDimensions))
+	for dimension := range rollupDimensions {
+		names = append(names, dimension)
+	}
+	sort.Strings(names)
+
+	values := make([]string, len(names))
+	for i, dimension := range names {
+		values[i] = `('` + dimension + `', ` + rollupDimensions[dimension] + `)`
+	}
+
+	return `INSERT INTO daily_click_dimensions (short_url_id, day, is_bot, dimension, value, clicks)
+	SELECT access_logs.short_url_id, (access_logs.accessed_at AT TIME ZONE 'UTC')::date, access_logs.is_bot,
+		dimensions.dimension, dimensions.value, COUNT(*)
+	FROM access_logs
+	CROSS JOIN LATERAL (VALUES ` + strings.Join(values, ",\n\t\t") + `) AS dimensions (dimension, value)
+	WHERE access_logs.short_url_id = $1 AND access_logs.accessed_at >= $2 AND access_logs.accessed_at < $3
+	GROUP BY 1, 2, 3, 4, 5
+	ON CONFLICT DO NOTHING`
+}()
+
+// rollupVisitorsQuery records the distinct visitors of short URL $1 between $2 and $3
+// per day, with their IP addresses as recorded in access_logs, so that they are
+// counted once together with the visitors of the days that aren't rolled up yet.
+const rollupVisitorsQuery = `INSERT INTO daily_visitors (short_url_id, day, is_bot, ip_address, ip_anonymized)
+	SELECT access_logs.short_url_id, (access_logs.accessed_at AT TIME ZONE 'UTC')::date, access_logs.is_bot,
+		access_logs.ip_address, bool_and(access_logs.ip_anonymized)
+	FROM access_logs
+	WHERE access_logs.short_url_id = $1 AND access_logs.accessed_at >= $2 AND access_logs.accessed_at < $3
+	GROUP BY 1, 2, 3, 4
+	ON CONFLICT DO NOTHING`
+
+// rollupClicksQuery aggregates the clicks of short URL $1 between $2 and $3 into daily_clicks.
+const rollupClicksQuery = `INSERT INTO daily_clicks (short_url_id, day, is_bot, clicks, unique_visitors)
+	SELECT access_logs.short_url_id, (access_logs.accessed_at AT TIME ZONE 'UTC')::date, access_logs.is_bot,
+		COUNT(*), COUNT(DISTINCT access_logs.ip_address)
+	FROM access_logs
+	WHERE access_logs.short_url_id = $1 AND access_logs.accessed_at >= $2 AND access_logs.accessed_at < $3
+	GROUP BY 1, 2, 3
+	ON CONFLICT DO NOTHING`
+
+// RollupTarget is a short URL whose clicks before Until are due to be rolled up.
+type RollupTarget struct {
+	ShortURLID int64
+	// Until is the UTC day the retention of the short URL starts at.
+	Until time.Time
+}
+
+type PostgresRetention struct {
+	db *sql.DB
+}
+
+// retentionCutoff is the first UTC day kept in access_logs for a short URL, given the
+// global retention $1 and the current UTC day $2.
+const retentionCutoff = `($2::date - COALESCE(short_urls.retention_days, $1))`
+
+// DueRollups returns the short URLs with a retention whose expired clicks haven't
+// been rolled up yet. globalDays applies to short URLs without their own retention,
+// 0 disables it.
+func (p *PostgresRetention) DueRollups(ctx context.Context, globalDays int, today time.Time) (_ []RollupTarget, err error) {
+	ctx, span := startSpan(ctx, "PostgresRetention.DueRollups", "SELECT", "short_urls")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT id, ` + retentionCutoff + ` FROM short_urls
+	WHERE COALESCE(retention_days, $1) > 0
+	AND (rolled_up_until IS NULL OR rolled_up_until < ` + retentionCutoff + `)
+	ORDER BY id`
+
+	rows, err := p.db.QueryContext(ctx, query, globalDays, today)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var targets []RollupTarget
+	for rows.Next() {
+		var target RollupTarget
+		if err := rows.Scan(&target.ShortURLID, &target.Until); err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+
+	return targets, rows.Err()
+}
+
+// PendingRollups returns the short URLs with clicks recorded on UTC days before today
+// that haven't been rolled up yet.
+func (p *PostgresRetention) PendingRollups(ctx context.Context, today time.Time) (_ []RollupTarget, err error) {
+	ctx, span := startSpan(ctx, "PostgresRetention.PendingRollups", "SELECT", "short_urls")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT short_urls.id FROM short_urls
+	WHERE (short_urls.rolled_up_until IS NULL OR short_urls.rolled_up_until < $1::date)
+	AND EXISTS (
+		SELECT 1 FROM access_logs
+		WHERE access_logs.short_url_id = short_urls.id
+		AND access_logs.accessed_at >= ` + rawClicksSince + `
+		AND access_logs.accessed_at < $1::date::timestamp AT TIME ZONE 'UTC'
+	)
+	ORDER BY short_urls.id`
+
+	rows, err := p.db.QueryContext(ctx, query, today)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var targets []RollupTarget
+	for rows.Next() {
+		target := RollupTarget{Until: today}
+		if err := rows.Scan(&target.ShortURLID); err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+
+	return targets, rows.Err()
+}
+
+// RollUp aggregates the clicks of a short URL recorded before the UTC day until into
+// the daily rollup tables, and moves its rolled_up_until forward so that queries read
+// those days from the rollups. Only the days not rolled up yet are aggregated. The
+// rolled up access logs are left in place until DeleteRolledUp removes them.
+func (p *PostgresRetention) RollUp(ctx context.Context, shortURLID int64, until time.Time) (err error) {
+	ctx, span := startSpan(ctx, "PostgresRetention.RollUp", "INSERT", "daily_clicks")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The advisory lock serializes concurrent jobs without blocking redirects, which
+	// update the same short_urls row.
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, hashtext($2::text))`, rollupLockClass, shortURLID); err != nil {
+		return err
+	}
+
+	var rolledUpUntil sql.NullTime
+	err = tx.QueryRowContext(ctx, `SELECT rolled_up_until FROM short_urls WHERE id = $1`, shortURLID).Scan(&rolledUpUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if rolledUpUntil.Valid && !rolledUpUntil.Time.Before(until) {
+		return nil
+	}
+
+	from := time.Time{}
+	if rolledUpUntil.Valid {
+		from = rolledUpUntil.Time
+	}
+
+	for _, query := range []string{rollupClicksQuery, rollupDimensionsQuery, rollupVisitorsQuery} {
+		if _, err := tx.ExecContext(ctx, query, shortURLID, from, until); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE short_urls SET rolled_up_until = $2 WHERE id = $1`, shortURLID, until)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteRolledUp deletes up to limit access logs that are older than the retention of
+// their short URL and already counted in the daily rollups, and returns how many were deleted.
+func (p *PostgresRetention) DeleteRolledUp(ctx context.Context, globalDays int, today time.Time, limit int) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "PostgresRetention.DeleteRolledUp", "DELETE", "access_logs")
+	defer func() { endSpan(span, err) }()
+
+	query := `DELETE FROM access_logs WHERE id IN (
+		SELECT access_logs.id FROM short_urls
+		JOIN access_logs ON access_logs.short_url_id = short_urls.id
+		WHERE short_urls.rolled_up_until IS NOT NULL
+		AND COALESCE(short_urls.retention_days, $1) > 0
+		AND access_logs.accessed_at < ` + rawClicksSince + `
+		AND access_logs.accessed_at < ` + retentionCutoff + `::timestamp AT TIME ZONE 'UTC'
+		LIMIT $3
+	)`
+
+	result, err := p.db.ExecContext(ctx, query, globalDays, today, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteExpired deletes up to limit access logs that are older than the retention of
+// their short URL without rolling them up, and returns how many were deleted.
+func (p *PostgresRetention) DeleteExpired(ctx context.Context, globalDays int, today time.Time, limit int) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "PostgresRetention.DeleteExpired", "DELETE", "access_logs")
+	defer func() { endSpan(span, err) }()
+
+	query := `DELETE FROM access_logs WHERE id IN (
+		SELECT access_logs.id FROM short_urls
+		JOIN access_logs ON access_logs.short_url_id = short_urls.id
+		WHERE COALESCE(short_urls.retention_days, $1) > 0
+		AND access_logs.accessed_at < ` + retentionCutoff + `::timestamp AT TIME ZONE 'UTC'
+		LIMIT $3
+	)`
+
+	result, err := p.db.ExecContext(ctx, query, globalDays, today, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteExpiredVisitors deletes up to limit rolled up visitors of days older than the
+// retention of their short URL, and returns how many were deleted.
+func (p *PostgresRetention) DeleteExpiredVisitors(ctx context.Context, globalDays int, today time.Time, limit int) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "PostgresRetention.DeleteExpiredVisitors", "DELETE", "daily_visitors")
+	defer func() { endSpan(span, err) }()
+
+	query := `DELETE FROM daily_visitors WHERE ctid IN (
+		SELECT daily_visitors.ctid FROM short_urls
+		JOIN daily_visitors ON daily_visitors.short_url_id = short_urls.id
+		WHERE COALESCE(short_urls.retention_days, $1) > 0
+		AND daily_visitors.day < ` + retentionCutoff + `
+		LIMIT $3
+	)`
+
+	result, err := p.db.ExecContext(ctx, query, globalDays, today, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
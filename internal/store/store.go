@@ -4,6 +4,21 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrNotFound is returned when the requested record does not exist.
+	ErrNotFound = errors.New("record not found")
+	// ErrDuplicateShortCode is returned when a short code is already taken.
+	ErrDuplicateShortCode = errors.New("short code already exists")
+	// ErrClickLimitReached is returned when a short URL can't be followed anymore.
+	ErrClickLimitReached = errors.New("click limit reached")
+	// ErrDuplicateCampaign is returned when an owner already has a campaign with the same name.
+	ErrDuplicateCampaign = errors.New("campaign already exists")
 )
 
 // Store represents a store for URL shorteners.
@@ -11,14 +26,56 @@ type Store struct {
 	Shortener interface {
 		Create(ctx context.Context, model *URLShortener) (*URLShortener, error)
 		FindWithShortCode(ctx context.Context, shortCode string) (*URLShortener, error)
-		UpdateRedirectCount(ctx context.Context, id int) error
+		UpdateRedirectCount(ctx context.Context, id int, bot bool) (int, error)
 		FindWithURL(ctx context.Context, shortURL string) (*URLShortener, error)
+		ListByOwner(ctx context.Context, ownerID, state string, limit int) ([]URLShortener, error)
+		Update(ctx context.Context, model *URLShortener) (*URLShortener, error)
+		Delete(ctx context.Context, id int) error
 	}
 	AccessLogs interface {
 		CreateLog(ctx context.Context, log *AccessLog) error
-		LastAccessed(ctx context.Context, shortCode string) (*AccessLog, error)
-		UniqueIPAddresses(ctx context.Context, shortCode string) ([]string, error)
-		TopUserAgents(ctx context.Context, shortCode string) ([]string, error)
+		LastAccessed(ctx context.Context, shortCode string, includeBots bool) (*AccessLog, error)
+		UniqueIPAddresses(ctx context.Context, shortCode string, includeBots bool) ([]string, error)
+		TopUserAgents(ctx context.Context, shortCode string, includeBots bool) ([]string, error)
+		ClickSeries(ctx context.Context, shortCode string, q ClickSeriesQuery) (*ClickSeries, error)
+		TopReferrers(ctx context.Context, shortCode string, groupBy string, limit int, includeBots bool) ([]ReferrerCount, error)
+		Breakdown(ctx context.Context, shortCode string, dimension string, limit int, includeBots bool) ([]DimensionCount, error)
+	}
+	Privacy interface {
+		DailySalt(ctx context.Context, day time.Time) ([]byte, error)
+		PendingAnonymization(ctx context.Context, afterID int64, limit int) ([]AccessLog, error)
+		AnonymizeIPs(ctx context.Context, ids []int64, ipAddresses []string) error
+		PendingVisitorAnonymization(ctx context.Context, limit int) ([]Visitor, error)
+		AnonymizeVisitors(ctx context.Context, visitors []Visitor, ipAddresses []string) error
+	}
+	Retention interface {
+		DueRollups(ctx context.Context, globalDays int, today time.Time) ([]RollupTarget, error)
+		PendingRollups(ctx context.Context, today time.Time) ([]RollupTarget, error)
+		RollUp(ctx context.Context, shortURLID int64, until time.Time) error
+		DeleteRolledUp(ctx context.Context, globalDays int, today time.Time, limit int) (int64, error)
+		DeleteExpired(ctx context.Context, globalDays int, today time.Time, limit int) (int64, error)
+		DeleteExpiredVisitors(ctx context.Context, globalDays int, today time.Time, limit int) (int64, error)
+	}
+	Webhooks interface {
+		Create(ctx context.Context, webhook *Webhook) (*Webhook, error)
+		List(ctx context.Context, ownerID string) ([]Webhook, error)
+		Find(ctx context.Context, ownerID string, iid uuid.UUID) (*Webhook, error)
+		Delete(ctx context.Context, ownerID string, iid uuid.UUID) error
+		Enqueue(ctx context.Context, ownerID, event string, payload []byte) (int64, error)
+		EnqueueClickThreshold(ctx context.Context, ownerID, event string, clicks int64, payload []byte) (int64, error)
+		EnqueueExpired(ctx context.Context, event string, limit int, payload func(*URLShortener) ([]byte, error)) (int, error)
+		ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]WebhookDelivery, error)
+		CountOverdue(ctx context.Context, lag time.Duration, limit int) (int, error)
+		DeleteFinished(ctx context.Context, before time.Time, limit int) (int64, error)
+		RecordAttempt(ctx context.Context, delivery *WebhookDelivery) error
+		ListDeliveries(ctx context.Context, webhookID int64, limit int) ([]WebhookDelivery, error)
+		Replay(ctx context.Context, webhookID int64, iid uuid.UUID) (*WebhookDelivery, error)
+	}
+	Campaigns interface {
+		Create(ctx context.Context, campaign *Campaign) (*Campaign, error)
+		List(ctx context.Context, ownerID string) ([]Campaign, error)
+		Find(ctx context.Context, ownerID, name string) (*Campaign, error)
+		Delete(ctx context.Context, ownerID, name string) error
 	}
 }
 
@@ -27,5 +84,9 @@ func NewStore(db *sql.DB) Store {
 	return Store{
 		Shortener:  &PostgresURLShortener{db: db},
 		AccessLogs: &PostgresAccessLogs{db: db},
+		Privacy:    &PostgresPrivacy{db: db},
+		Retention:  &PostgresRetention{db: db},
+		Webhooks:   &PostgresWebhooks{db: db},
+		Campaigns:  &PostgresCampaigns{db: db},
 	}
 }
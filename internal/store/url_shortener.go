@@ -3,37 +3,233 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"github.com/nccapo/url-sh/internal/targeting"
 )
 
+// uniqueViolation is the Postgres error code raised when a unique constraint is violated.
+const uniqueViolation = "23505"
+
 type URLShortener struct {
-	ID            int       `json:"-"`
-	IID           uuid.UUID `json:"iid"`
-	OriginalURL   string    `json:"original_url"`
-	ShortCode     string    `json:"short_code"`
+	ID          int       `json:"-"`
+	IID         uuid.UUID `json:"iid"`
+	OriginalURL string    `json:"original_url"`
+	ShortCode   string    `json:"short_code"`
+	// BaseURL is the base the short code is served under, without the code itself.
 	BaseURL       string    `json:"-"`
 	ShortURL      string    `json:"short_url"`
 	Expiration    time.Time `json:"expiration"`
 	RedirectCount int       `json:"redirect_count"`
-	LastAccessed  time.Time `json:"last_accessed"`
-	LastModified  time.Time `json:"last_modified"`
-	Method        string    `json:"method"`
+	// BotRedirectCount counts redirects classified as automated, excluded from RedirectCount.
+	BotRedirectCount int       `json:"bot_redirect_count"`
+	LastAccessed     time.Time `json:"last_accessed"`
+	LastModified     time.Time `json:"last_modified"`
+	CreatedAt        time.Time `json:"created_at"`
+	Method           string    `json:"method"`
 	// UTM Parameters
 	UTMSource   string `json:"utm_source,omitempty"`
 	UTMMedium   string `json:"utm_medium,omitempty"`
 	UTMCampaign string `json:"utm_campaign,omitempty"`
 	UTMTerm     string `json:"utm_term,omitempty"`
 	UTMContent  string `json:"utm_content,omitempty"`
+	// RetentionDays overrides the global retention of raw clicks, nil if unset.
+	RetentionDays *int `json:"retention_days,omitempty"`
+	// OwnerID identifies the owner who created the short URL, empty if anonymous.
+	OwnerID string `json:"-"`
+	// RedirectStatus overrides the redirect status configured for the deployment, nil if unset.
+	RedirectStatus *int `json:"redirect_status,omitempty"`
+	// CacheRedirects lets browsers and proxies cache the redirect, at the cost of
+	// not counting the clicks served from their cache.
+	CacheRedirects bool `json:"cache_redirects"`
+	// PasswordHash is the bcrypt hash of the password required to follow the short
+	// URL, empty if it isn't protected.
+	PasswordHash string `json:"-"`
+	// PasswordProtected reports whether PasswordHash is set.
+	PasswordProtected bool `json:"password_protected"`
+	// MaxClicks is the number of times the short URL can be followed, nil if unlimited.
+	// Bot clicks count towards it as well, they reveal the target just the same.
+	MaxClicks *int `json:"max_clicks,omitempty"`
+	// RemainingClicks is the number of times the short URL can still be followed,
+	// nil if unlimited.
+	RemainingClicks *int `json:"remaining_clicks,omitempty"`
+	// ActiveFrom and ActiveUntil bound the window the short URL can be followed in,
+	// nil leaves the window open on that side.
+	ActiveFrom  *time.Time `json:"active_from,omitempty"`
+	ActiveUntil *time.Time `json:"active_until,omitempty"`
+	// ScheduledFallbackURL is where visitors are sent before the activation window,
+	// and EndedFallbackURL after it. Empty for the fallback of the deployment.
+	ScheduledFallbackURL string `json:"scheduled_fallback_url,omitempty"`
+	EndedFallbackURL     string `json:"ended_fallback_url,omitempty"`
+	// TargetingRules pick the destination of clicks, OriginalURL is the destination
+	// of the clicks matching none of them.
+	TargetingRules targeting.Rules `json:"targeting_rules"`
+	// Variants split the clicks matching no targeting rule between several
+	// destinations in place of OriginalURL.
+	Variants targeting.Variants `json:"variants"`
+	// StickyVariants keeps serving a visitor the variant they were first served.
+	StickyVariants bool `json:"sticky_variants"`
+	// ForwardQuery merges the query string of the short URL into the destination.
+	ForwardQuery bool `json:"forward_query"`
+	// ForwardPath makes a wildcard short URL, which appends the sub-path it is
+	// requested with to the path of the destination.
+	ForwardPath bool `json:"forward_path"`
+	// QueryPrecedence decides which value is kept when a forwarded query parameter is
+	// already set by the destination or the UTM parameters: one of the QueryPrecedence
+	// constants.
+	QueryPrecedence string `json:"query_precedence"`
+	// UTMPolicy decides what happens to the UTM and tracking parameters the
+	// destination already has: one of the UTMPolicy constants, empty for the policy
+	// of the deployment.
+	UTMPolicy string `json:"utm_policy,omitempty"`
+	// TrackingParams are added to the destination next to the UTM parameters.
+	TrackingParams TrackingParams `json:"tracking_params"`
+	// Preview serves a preview page showing the destination in place of the
+	// redirect, the click is only counted when the visitor continues from it.
+	Preview bool `json:"preview"`
+	// OGTitle, OGDescription and OGImage are the Open Graph metadata of the preview
+	// page, which is served to social unfurlers when any of them is set.
+	OGTitle       string `json:"og_title,omitempty"`
+	OGDescription string `json:"og_description,omitempty"`
+	OGImage       string `json:"og_image,omitempty"`
+}
+
+// Precedences of forwarded query parameters over the stored ones.
+const (
+	QueryPrecedenceStored   = "stored"
+	QueryPrecedenceIncoming = "incoming"
+)
+
+// Policies for the UTM and tracking parameters already set by the destination.
+const (
+	// UTMPolicyOverride replaces them with the parameters of the short URL.
+	UTMPolicyOverride = "override"
+	// UTMPolicyKeep keeps them, the parameters of the short URL are only added when
+	// the destination doesn't have them.
+	UTMPolicyKeep = "keep"
+)
+
+// States of the activation window of a short URL.
+const (
+	LinkStateScheduled = "scheduled"
+	LinkStateActive    = "active"
+	LinkStateEnded     = "ended"
+)
+
+// linkStateConditions select the short URLs in each state of their activation window.
+var linkStateConditions = map[string]string{
+	LinkStateScheduled: `active_from > NOW()`,
+	LinkStateActive:    `(active_from IS NULL OR active_from <= NOW()) AND (active_until IS NULL OR active_until > NOW())`,
+	LinkStateEnded:     `active_until <= NOW()`,
+}
+
+// IsLinkState reports whether state is one of the states of the activation window.
+func IsLinkState(state string) bool {
+	_, ok := linkStateConditions[state]
+	return ok
 }
 
 type PostgresURLShortener struct {
 	db *sql.DB
 }
 
+// shortURLColumns lists the short_urls columns read by scanShortURL, in order.
+const shortURLColumns = `id, iid, original_url, short_code, base_url, expiration,
+	redirect_count, last_accessed, last_modified, method, utm_source,
+	utm_medium, utm_campaign, utm_term, utm_content, bot_redirect_count,
+	retention_days, owner_id, redirect_status, cache_redirects, password_hash, max_clicks,
+	active_from, active_until, scheduled_fallback_url, ended_fallback_url, targeting_rules,
+	variants, sticky_variants, forward_query, forward_path, query_precedence, utm_policy,
+	tracking_params, preview, og_title, og_description, og_image, created_at`
+
+// rowScanner is implemented by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanShortURL scans a row selected with shortURLColumns.
+func scanShortURL(row rowScanner) (*URLShortener, error) {
+	var model URLShortener
+	err := row.Scan(
+		&model.ID,
+		&model.IID,
+		&model.OriginalURL,
+		&model.ShortCode,
+		&model.BaseURL,
+		&model.Expiration,
+		&model.RedirectCount,
+		&model.LastAccessed,
+		&model.LastModified,
+		&model.Method,
+		&model.UTMSource,
+		&model.UTMMedium,
+		&model.UTMCampaign,
+		&model.UTMTerm,
+		&model.UTMContent,
+		&model.BotRedirectCount,
+		&model.RetentionDays,
+		&model.OwnerID,
+		&model.RedirectStatus,
+		&model.CacheRedirects,
+		&model.PasswordHash,
+		&model.MaxClicks,
+		&model.ActiveFrom,
+		&model.ActiveUntil,
+		&model.ScheduledFallbackURL,
+		&model.EndedFallbackURL,
+		&model.TargetingRules,
+		&model.Variants,
+		&model.StickyVariants,
+		&model.ForwardQuery,
+		&model.ForwardPath,
+		&model.QueryPrecedence,
+		&model.UTMPolicy,
+		&model.TrackingParams,
+		&model.Preview,
+		&model.OGTitle,
+		&model.OGDescription,
+		&model.OGImage,
+		&model.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	model.fillComputed()
+
+	return &model, nil
+}
+
+// fillComputed sets the fields derived from the stored ones.
+func (u *URLShortener) fillComputed() {
+	u.ShortURL = u.formatShortURL()
+	u.PasswordProtected = u.PasswordHash != ""
+	if u.TargetingRules == nil {
+		u.TargetingRules = targeting.Rules{}
+	}
+	if u.Variants == nil {
+		u.Variants = targeting.Variants{}
+	}
+	if u.TrackingParams == nil {
+		u.TrackingParams = TrackingParams{}
+	}
+
+	u.RemainingClicks = nil
+	if u.MaxClicks != nil {
+		remaining := max(*u.MaxClicks-u.RedirectCount-u.BotRedirectCount, 0)
+		u.RemainingClicks = &remaining
+	}
+}
+
 // formatShortURL combines base URL with path and ensures proper formatting
 func (u *URLShortener) formatShortURL() string {
 	// Remove trailing slash from base URL if present
@@ -43,15 +239,49 @@ func (u *URLShortener) formatShortURL() string {
 	return fmt.Sprintf("%s/%s", baseURL, path)
 }
 
-func (p *PostgresURLShortener) Create(ctx context.Context, model *URLShortener) (*URLShortener, error) {
+// IsExpired reports whether the short URL has an expiration set that lies before now.
+func (u *URLShortener) IsExpired(now time.Time) bool {
+	return !u.Expiration.IsZero() && now.After(u.Expiration)
+}
+
+// State returns the state of the activation window of the short URL at now.
+func (u *URLShortener) State(now time.Time) string {
+	switch {
+	case u.ActiveFrom != nil && now.Before(*u.ActiveFrom):
+		return LinkStateScheduled
+	case u.ActiveUntil != nil && !now.Before(*u.ActiveUntil):
+		return LinkStateEnded
+	}
+	return LinkStateActive
+}
+
+// IsExhausted reports whether the short URL has a click limit it already reached.
+func (u *URLShortener) IsExhausted() bool {
+	return u.RemainingClicks != nil && *u.RemainingClicks == 0
+}
+
+// HasOpenGraph reports whether any of the Open Graph metadata of the short URL is set.
+func (u *URLShortener) HasOpenGraph() bool {
+	return u.OGTitle != "" || u.OGDescription != "" || u.OGImage != ""
+}
+
+func (p *PostgresURLShortener) Create(ctx context.Context, model *URLShortener) (_ *URLShortener, err error) {
+	ctx, span := startSpan(ctx, "PostgresURLShortener.Create", "INSERT", "short_urls")
+	defer func() { endSpan(span, err) }()
+
 	query := `INSERT INTO short_urls (
 		original_url, short_code, base_url, expiration, redirect_count,
 		last_accessed, last_modified, method, utm_source, utm_medium,
-		utm_campaign, utm_term, utm_content
-	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING id, iid`
+		utm_campaign, utm_term, utm_content, retention_days, owner_id,
+		redirect_status, cache_redirects, password_hash, max_clicks, active_from,
+		active_until, scheduled_fallback_url, ended_fallback_url, targeting_rules,
+		variants, sticky_variants, forward_query, forward_path, query_precedence,
+		utm_policy, tracking_params, preview, og_title, og_description, og_image
+	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19,
+		$20, $21, $22, $23, $24, $25, $26, $27, $28, $29, $30, $31, $32, $33, $34, $35)
+	RETURNING id, iid, created_at`
 
-	model.BaseURL = model.formatShortURL()
-	err := p.db.QueryRowContext(ctx, query,
+	err = p.db.QueryRowContext(ctx, query,
 		model.OriginalURL,
 		model.ShortCode,
 		model.BaseURL,
@@ -65,89 +295,188 @@ func (p *PostgresURLShortener) Create(ctx context.Context, model *URLShortener)
 		model.UTMCampaign,
 		model.UTMTerm,
 		model.UTMContent,
-	).Scan(&model.ID, &model.IID)
+		model.RetentionDays,
+		model.OwnerID,
+		model.RedirectStatus,
+		model.CacheRedirects,
+		model.PasswordHash,
+		model.MaxClicks,
+		model.ActiveFrom,
+		model.ActiveUntil,
+		model.ScheduledFallbackURL,
+		model.EndedFallbackURL,
+		model.TargetingRules,
+		model.Variants,
+		model.StickyVariants,
+		model.ForwardQuery,
+		model.ForwardPath,
+		model.QueryPrecedence,
+		model.UTMPolicy,
+		model.TrackingParams,
+		model.Preview,
+		model.OGTitle,
+		model.OGDescription,
+		model.OGImage,
+	).Scan(&model.ID, &model.IID, &model.CreatedAt)
 	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+			return nil, ErrDuplicateShortCode
+		}
 		return nil, err
 	}
 
-	model.ShortURL = model.formatShortURL()
+	model.fillComputed()
 
 	return model, nil
 }
 
-func (p *PostgresURLShortener) FindWithShortCode(ctx context.Context, shortCode string) (*URLShortener, error) {
-	query := `SELECT id, iid, original_url, short_code, base_url, expiration,
-		redirect_count, last_accessed, last_modified, method, utm_source,
-		utm_medium, utm_campaign, utm_term, utm_content
-		FROM short_urls WHERE short_code = $1`
+func (p *PostgresURLShortener) FindWithShortCode(ctx context.Context, shortCode string) (_ *URLShortener, err error) {
+	ctx, span := startSpan(ctx, "PostgresURLShortener.FindWithShortCode", "SELECT", "short_urls")
+	defer func() { endSpan(span, err) }()
 
-	var model URLShortener
-	err := p.db.QueryRowContext(ctx, query, shortCode).Scan(
-		&model.ID,
-		&model.IID,
-		&model.OriginalURL,
-		&model.ShortCode,
-		&model.BaseURL,
-		&model.Expiration,
-		&model.RedirectCount,
-		&model.LastAccessed,
-		&model.LastModified,
-		&model.Method,
-		&model.UTMSource,
-		&model.UTMMedium,
-		&model.UTMCampaign,
-		&model.UTMTerm,
-		&model.UTMContent,
-	)
+	query := `SELECT ` + shortURLColumns + ` FROM short_urls WHERE short_code = $1`
+
+	return scanShortURL(p.db.QueryRowContext(ctx, query, shortCode))
+}
+
+// FindWithURL finds a short URL by its short code or by the full short URL.
+func (p *PostgresURLShortener) FindWithURL(ctx context.Context, shortURL string) (_ *URLShortener, err error) {
+	ctx, span := startSpan(ctx, "PostgresURLShortener.FindWithURL", "SELECT", "short_urls")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT ` + shortURLColumns + ` FROM short_urls
+		WHERE short_code = $1 OR rtrim(base_url, '/') || '/' || short_code = $1
+		LIMIT 1`
+
+	return scanShortURL(p.db.QueryRowContext(ctx, query, shortURL))
+}
+
+// ListByOwner returns the latest limit short URLs of an owner, newest first. state
+// restricts them to one of the states of their activation window, empty for all.
+func (p *PostgresURLShortener) ListByOwner(ctx context.Context, ownerID, state string, limit int) (_ []URLShortener, err error) {
+	ctx, span := startSpan(ctx, "PostgresURLShortener.ListByOwner", "SELECT", "short_urls")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT ` + shortURLColumns + ` FROM short_urls WHERE owner_id = $1`
+	if condition, ok := linkStateConditions[state]; ok {
+		query += ` AND ` + condition
+	}
+	query += ` ORDER BY id DESC LIMIT $2`
+
+	rows, err := p.db.QueryContext(ctx, query, ownerID, limit)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	model.ShortURL = model.BaseURL
+	links := []URLShortener{}
+	for rows.Next() {
+		link, err := scanShortURL(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, *link)
+	}
 
-	return &model, nil
+	return links, rows.Err()
 }
 
-func (p *PostgresURLShortener) FindWithURL(ctx context.Context, shortURL string) (*URLShortener, error) {
-	query := `SELECT id, iid, original_url, short_code, base_url, expiration,
-		redirect_count, last_accessed, last_modified, method, utm_source,
-		utm_medium, utm_campaign, utm_term, utm_content
-		FROM short_urls WHERE base_url = $1 OR short_code = $1`
+// Update saves the settings of a short URL that can be changed after its creation,
+// and returns the short URL as stored.
+func (p *PostgresURLShortener) Update(ctx context.Context, model *URLShortener) (_ *URLShortener, err error) {
+	ctx, span := startSpan(ctx, "PostgresURLShortener.Update", "UPDATE", "short_urls")
+	defer func() { endSpan(span, err) }()
 
-	var model URLShortener
-	err := p.db.QueryRowContext(ctx, query, shortURL).Scan(
-		&model.ID,
-		&model.IID,
-		&model.OriginalURL,
-		&model.ShortCode,
-		&model.BaseURL,
-		&model.Expiration,
-		&model.RedirectCount,
-		&model.LastAccessed,
-		&model.LastModified,
-		&model.Method,
-		&model.UTMSource,
-		&model.UTMMedium,
-		&model.UTMCampaign,
-		&model.UTMTerm,
-		&model.UTMContent,
-	)
-	if err != nil {
-		return nil, err
+	query := `UPDATE short_urls SET
+		original_url = $2, utm_source = $3, utm_medium = $4, utm_campaign = $5,
+		utm_term = $6, utm_content = $7, retention_days = $8, redirect_status = $9,
+		cache_redirects = $10, password_hash = $11, max_clicks = $12, active_from = $13,
+		active_until = $14, scheduled_fallback_url = $15, ended_fallback_url = $16, targeting_rules = $17,
+		variants = $18, sticky_variants = $19, forward_query = $20, forward_path = $21,
+		query_precedence = $22, utm_policy = $23, tracking_params = $24,
+		preview = $25, og_title = $26, og_description = $27, og_image = $28, last_modified = NOW()
+	WHERE id = $1 RETURNING ` + shortURLColumns
+
+	return scanShortURL(p.db.QueryRowContext(ctx, query,
+		model.ID,
+		model.OriginalURL,
+		model.UTMSource,
+		model.UTMMedium,
+		model.UTMCampaign,
+		model.UTMTerm,
+		model.UTMContent,
+		model.RetentionDays,
+		model.RedirectStatus,
+		model.CacheRedirects,
+		model.PasswordHash,
+		model.MaxClicks,
+		model.ActiveFrom,
+		model.ActiveUntil,
+		model.ScheduledFallbackURL,
+		model.EndedFallbackURL,
+		model.TargetingRules,
+		model.Variants,
+		model.StickyVariants,
+		model.ForwardQuery,
+		model.ForwardPath,
+		model.QueryPrecedence,
+		model.UTMPolicy,
+		model.TrackingParams,
+		model.Preview,
+		model.OGTitle,
+		model.OGDescription,
+		model.OGImage,
+	))
+}
+
+// UpdateRedirectCount increments the redirect count of a short URL, or its bot
+// redirect count when the click was classified as automated, and returns the
+// incremented count. The click limit of the short URL is checked in the same
+// statement, so that concurrent clicks can't exceed it: ErrClickLimitReached is
+// returned, and nothing is counted, once it is reached.
+func (p *PostgresURLShortener) UpdateRedirectCount(ctx context.Context, id int, bot bool) (_ int, err error) {
+	ctx, span := startSpan(ctx, "PostgresURLShortener.UpdateRedirectCount", "UPDATE", "short_urls")
+	defer func() { endSpan(span, err) }()
+
+	column := "redirect_count"
+	if bot {
+		column = "bot_redirect_count"
 	}
 
-	model.ShortURL = model.BaseURL
+	query := `UPDATE short_urls SET ` + column + ` = ` + column + ` + 1
+	WHERE id = $1 AND (max_clicks IS NULL OR redirect_count + bot_redirect_count < max_clicks)
+	RETURNING ` + column
 
-	return &model, nil
+	var count int
+	err = p.db.QueryRowContext(ctx, query, id).Scan(&count)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The short URL either reached its limit or was deleted since it was found.
+		return 0, ErrClickLimitReached
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
 }
 
-func (p *PostgresURLShortener) UpdateRedirectCount(ctx context.Context, id int) error {
-	query := `UPDATE short_urls SET redirect_count = redirect_count + 1 WHERE id = $1`
+// Delete removes a short URL together with its clicks.
+func (p *PostgresURLShortener) Delete(ctx context.Context, id int) (err error) {
+	ctx, span := startSpan(ctx, "PostgresURLShortener.Delete", "DELETE", "short_urls")
+	defer func() { endSpan(span, err) }()
 
-	_, err := p.db.ExecContext(ctx, query, id)
+	result, err := p.db.ExecContext(ctx, `DELETE FROM short_urls WHERE id = $1`, id)
 	if err != nil {
 		return err
 	}
 
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
 	return nil
 }
@@ -0,0 +1,402 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Delivery statuses of a WebhookDelivery.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	// DeliveryFailed deliveries ran out of attempts, they are only retried when replayed.
+	DeliveryFailed = "failed"
+)
+
+// Webhook is an endpoint receiving the events of the short URLs of its owner.
+type Webhook struct {
+	ID      int64     `json:"-"`
+	IID     uuid.UUID `json:"id"`
+	OwnerID string    `json:"-"`
+	URL     string    `json:"url"`
+	Events  []string  `json:"events"`
+	// ClickThresholds are the redirect counts the click threshold event is sent at.
+	ClickThresholds []int64   `json:"click_thresholds"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is an event payload queued for a webhook, with the outcome of its attempts.
+type WebhookDelivery struct {
+	ID             int64      `json:"-"`
+	IID            uuid.UUID  `json:"id"`
+	WebhookID      int64      `json:"-"`
+	WebhookIID     uuid.UUID  `json:"webhook_id"`
+	WebhookURL     string     `json:"-"`
+	Event          string     `json:"event"`
+	Payload        string     `json:"payload"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at"`
+	LastAttemptAt  *time.Time `json:"last_attempt_at"`
+	LastStatusCode int        `json:"last_status_code"`
+	LastError      string     `json:"last_error"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+type PostgresWebhooks struct {
+	db *sql.DB
+}
+
+const webhookColumns = `id, iid, owner_id, url, events, click_thresholds, created_at`
+
+const deliveryColumns = `webhook_deliveries.id, webhook_deliveries.iid, webhook_deliveries.webhook_id,
+	webhooks.iid, webhooks.url, webhook_deliveries.event, webhook_deliveries.payload, webhook_deliveries.status,
+	webhook_deliveries.attempts, webhook_deliveries.next_attempt_at, webhook_deliveries.last_attempt_at,
+	webhook_deliveries.last_status_code, webhook_deliveries.last_error, webhook_deliveries.created_at`
+
+func scanWebhook(row rowScanner) (*Webhook, error) {
+	var webhook Webhook
+	err := row.Scan(
+		&webhook.ID,
+		&webhook.IID,
+		&webhook.OwnerID,
+		&webhook.URL,
+		pq.Array(&webhook.Events),
+		pq.Array(&webhook.ClickThresholds),
+		&webhook.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func scanDelivery(row rowScanner) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	err := row.Scan(
+		&delivery.ID,
+		&delivery.IID,
+		&delivery.WebhookID,
+		&delivery.WebhookIID,
+		&delivery.WebhookURL,
+		&delivery.Event,
+		&delivery.Payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.NextAttemptAt,
+		&delivery.LastAttemptAt,
+		&delivery.LastStatusCode,
+		&delivery.LastError,
+		&delivery.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// Create registers a webhook.
+func (p *PostgresWebhooks) Create(ctx context.Context, webhook *Webhook) (_ *Webhook, err error) {
+	ctx, span := startSpan(ctx, "PostgresWebhooks.Create", "INSERT", "webhooks")
+	defer func() { endSpan(span, err) }()
+
+	query := `INSERT INTO webhooks (owner_id, url, events, click_thresholds)
+	VALUES ($1, $2, $3, $4) RETURNING ` + webhookColumns
+
+	return scanWebhook(p.db.QueryRowContext(ctx, query,
+		webhook.OwnerID, webhook.URL, pq.Array(webhook.Events), pq.Array(webhook.ClickThresholds)))
+}
+
+// List returns the webhooks of an owner, oldest first.
+func (p *PostgresWebhooks) List(ctx context.Context, ownerID string) (_ []Webhook, err error) {
+	ctx, span := startSpan(ctx, "PostgresWebhooks.List", "SELECT", "webhooks")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT ` + webhookColumns + ` FROM webhooks WHERE owner_id = $1 ORDER BY id`
+
+	rows, err := p.db.QueryContext(ctx, query, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, *webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// Find returns a webhook of an owner.
+func (p *PostgresWebhooks) Find(ctx context.Context, ownerID string, iid uuid.UUID) (_ *Webhook, err error) {
+	ctx, span := startSpan(ctx, "PostgresWebhooks.Find", "SELECT", "webhooks")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT ` + webhookColumns + ` FROM webhooks WHERE owner_id = $1 AND iid = $2`
+
+	return scanWebhook(p.db.QueryRowContext(ctx, query, ownerID, iid))
+}
+
+// Delete removes a webhook of an owner together with its deliveries.
+func (p *PostgresWebhooks) Delete(ctx context.Context, ownerID string, iid uuid.UUID) (err error) {
+	ctx, span := startSpan(ctx, "PostgresWebhooks.Delete", "DELETE", "webhooks")
+	defer func() { endSpan(span, err) }()
+
+	result, err := p.db.ExecContext(ctx, `DELETE FROM webhooks WHERE owner_id = $1 AND iid = $2`, ownerID, iid)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Enqueue queues payload for every webhook of the owner subscribed to event, and
+// returns the number of queued deliveries.
+func (p *PostgresWebhooks) Enqueue(ctx context.Context, ownerID, event string, payload []byte) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "PostgresWebhooks.Enqueue", "INSERT", "webhook_deliveries")
+	defer func() { endSpan(span, err) }()
+
+	query := `INSERT INTO webhook_deliveries (webhook_id, event, payload)
+	SELECT id, $2, $3 FROM webhooks WHERE owner_id = $1 AND $2 = ANY(events)`
+
+	result, err := p.db.ExecContext(ctx, query, ownerID, event, string(payload))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// EnqueueClickThreshold queues payload for every webhook of the owner subscribed to
+// event with clicks among its click thresholds, and returns the number of queued deliveries.
+func (p *PostgresWebhooks) EnqueueClickThreshold(ctx context.Context, ownerID, event string, clicks int64, payload []byte) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "PostgresWebhooks.EnqueueClickThreshold", "INSERT", "webhook_deliveries")
+	defer func() { endSpan(span, err) }()
+
+	query := `INSERT INTO webhook_deliveries (webhook_id, event, payload)
+	SELECT id, $2, $4 FROM webhooks WHERE owner_id = $1 AND $2 = ANY(events) AND $3 = ANY(click_thresholds)`
+
+	result, err := p.db.ExecContext(ctx, query, ownerID, event, clicks, string(payload))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// EnqueueExpired marks up to limit expired short URLs of owners as notified and queues
+// the payload built by payload for the webhooks of their owner subscribed to event,
+// in one transaction. It returns the number of short URLs marked.
+func (p *PostgresWebhooks) EnqueueExpired(ctx context.Context, event string, limit int, payload func(*URLShortener) ([]byte, error)) (_ int, err error) {
+	ctx, span := startSpan(ctx, "PostgresWebhooks.EnqueueExpired", "INSERT", "webhook_deliveries")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// A zero expiration is stored for short URLs that never expire.
+	query := `SELECT ` + shortURLColumns + ` FROM short_urls
+	WHERE owner_id <> '' AND NOT expiry_notified
+	AND expiration > '0001-01-01T00:00:00Z' AND expiration <= NOW()
+	ORDER BY id LIMIT $1
+	FOR UPDATE SKIP LOCKED`
+
+	rows, err := tx.QueryContext(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+	var links []*URLShortener
+	for rows.Next() {
+		link, err := scanShortURL(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		links = append(links, link)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, link := range links {
+		body, err := payload(link)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO webhook_deliveries (webhook_id, event, payload)
+		SELECT id, $2, $3 FROM webhooks WHERE owner_id = $1 AND $2 = ANY(events)`, link.OwnerID, event, string(body))
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE short_urls SET expiry_notified = true WHERE id = $1`, link.ID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(links), tx.Commit()
+}
+
+// ClaimDue returns up to limit pending deliveries whose next attempt is due, and
+// postpones their next attempt by lease so that other dispatchers skip them while
+// they are being delivered.
+func (p *PostgresWebhooks) ClaimDue(ctx context.Context, limit int, lease time.Duration) (_ []WebhookDelivery, err error) {
+	ctx, span := startSpan(ctx, "PostgresWebhooks.ClaimDue", "UPDATE", "webhook_deliveries")
+	defer func() { endSpan(span, err) }()
+
+	query := `UPDATE webhook_deliveries SET next_attempt_at = NOW() + $2 * interval '1 second'
+	FROM webhooks
+	WHERE webhooks.id = webhook_deliveries.webhook_id
+	AND webhook_deliveries.id IN (
+		SELECT id FROM webhook_deliveries
+		WHERE status = 'pending' AND next_attempt_at <= NOW()
+		ORDER BY next_attempt_at LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING ` + deliveryColumns
+
+	rows, err := p.db.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// CountOverdue returns the number of pending deliveries that have been due for longer
+// than lag, counting at most limit of them.
+func (p *PostgresWebhooks) CountOverdue(ctx context.Context, lag time.Duration, limit int) (_ int, err error) {
+	ctx, span := startSpan(ctx, "PostgresWebhooks.CountOverdue", "SELECT", "webhook_deliveries")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT COUNT(*) FROM (
+		SELECT 1 FROM webhook_deliveries
+		WHERE status = 'pending' AND next_attempt_at <= NOW() - $1 * interval '1 second'
+		LIMIT $2
+	) AS overdue`
+
+	var count int
+	err = p.db.QueryRowContext(ctx, query, lag.Seconds(), limit).Scan(&count)
+	return count, err
+}
+
+// DeleteFinished deletes up to limit delivered or failed deliveries queued before
+// the given time, and returns how many were deleted. Pending deliveries, replayed
+// ones included, are kept.
+func (p *PostgresWebhooks) DeleteFinished(ctx context.Context, before time.Time, limit int) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "PostgresWebhooks.DeleteFinished", "DELETE", "webhook_deliveries")
+	defer func() { endSpan(span, err) }()
+
+	query := `DELETE FROM webhook_deliveries WHERE id IN (
+		SELECT id FROM webhook_deliveries
+		WHERE status <> 'pending' AND created_at < $1
+		LIMIT $2
+	)`
+
+	result, err := p.db.ExecContext(ctx, query, before, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RecordAttempt stores the outcome of a delivery attempt: its status, attempts,
+// next attempt and last status code and error.
+func (p *PostgresWebhooks) RecordAttempt(ctx context.Context, delivery *WebhookDelivery) (err error) {
+	ctx, span := startSpan(ctx, "PostgresWebhooks.RecordAttempt", "UPDATE", "webhook_deliveries")
+	defer func() { endSpan(span, err) }()
+
+	query := `UPDATE webhook_deliveries SET status = $2, attempts = $3, next_attempt_at = $4,
+	last_attempt_at = $5, last_status_code = $6, last_error = $7
+	WHERE id = $1`
+
+	_, err = p.db.ExecContext(ctx, query,
+		delivery.ID,
+		delivery.Status,
+		delivery.Attempts,
+		delivery.NextAttemptAt,
+		delivery.LastAttemptAt,
+		delivery.LastStatusCode,
+		delivery.LastError,
+	)
+	return err
+}
+
+// ListDeliveries returns the latest limit deliveries of a webhook, newest first.
+func (p *PostgresWebhooks) ListDeliveries(ctx context.Context, webhookID int64, limit int) (_ []WebhookDelivery, err error) {
+	ctx, span := startSpan(ctx, "PostgresWebhooks.ListDeliveries", "SELECT", "webhook_deliveries")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries
+	JOIN webhooks ON webhooks.id = webhook_deliveries.webhook_id
+	WHERE webhook_deliveries.webhook_id = $1
+	ORDER BY webhook_deliveries.created_at DESC, webhook_deliveries.id DESC LIMIT $2`
+
+	rows, err := p.db.QueryContext(ctx, query, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// Replay queues a delivery of a webhook again, with a fresh set of attempts.
+func (p *PostgresWebhooks) Replay(ctx context.Context, webhookID int64, iid uuid.UUID) (_ *WebhookDelivery, err error) {
+	ctx, span := startSpan(ctx, "PostgresWebhooks.Replay", "UPDATE", "webhook_deliveries")
+	defer func() { endSpan(span, err) }()
+
+	query := `UPDATE webhook_deliveries SET status = 'pending', attempts = 0, next_attempt_at = NOW()
+	FROM webhooks
+	WHERE webhooks.id = webhook_deliveries.webhook_id
+	AND webhook_deliveries.webhook_id = $1 AND webhook_deliveries.iid = $2
+	RETURNING ` + deliveryColumns
+
+	return scanDelivery(p.db.QueryRowContext(ctx, query, webhookID, iid))
+}
@@ -0,0 +1,44 @@
+package targeting
+
+import (
+	"strconv"
+	"strings"
+)
+
+// PreferredLanguage returns the language tag with the highest quality in an
+// Accept-Language header, the first one among equals. Wildcards and refused
+// languages are ignored, an empty string is returned if none is left.
+func PreferredLanguage(header string) string {
+	var (
+		best  string
+		bestQ float64
+	)
+
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if !ok || strings.TrimSpace(name) != "q" {
+				continue
+			}
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				q = 0
+			} else {
+				q = parsed
+			}
+		}
+
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,55 @@
+package targeting
+
+import "testing"
+
+func TestPreferredLanguage(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"empty", "", ""},
+		{"single", "fr-CH", "fr-CH"},
+		{"first among equals", "fr, en", "fr"},
+		{"highest quality", "en;q=0.5, de;q=0.9, fr;q=0.7", "de"},
+		{"quality defaults to 1", "en;q=0.9, de", "de"},
+		{"spaces", " en ; q = 0.2 ,  it ; q=0.8 ", "it"},
+		{"wildcard ignored", "*, fr;q=0.5", "fr"},
+		{"only wildcard", "*", ""},
+		{"refused language ignored", "de;q=0, en;q=0.1", "en"},
+		{"only refused", "de;q=0", ""},
+		{"invalid quality refuses", "de;q=high, en;q=0.3", "en"},
+		{"other parameters ignored", "de;level=1;q=0.4, en;q=0.3", "de"},
+		{"empty parts ignored", ",,en-GB;q=0.8,", "en-GB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PreferredLanguage(tt.header); got != tt.want {
+				t.Errorf("PreferredLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesLanguage(t *testing.T) {
+	tests := []struct {
+		tags     []string
+		language string
+		want     bool
+	}{
+		{[]string{"en"}, "en", true},
+		{[]string{"en"}, "en-US", true},
+		{[]string{"EN"}, "en-us", true},
+		{[]string{"en-US"}, "en-US", true},
+		{[]string{"en-US"}, "en-GB", false},
+		{[]string{"en-US"}, "en", false},
+		{[]string{"en"}, "eng", false},
+		{[]string{"de", "fr"}, "fr-CA", true},
+		{[]string{"en"}, "", false},
+	}
+	for _, tt := range tests {
+		if got := matchesLanguage(tt.tags, tt.language); got != tt.want {
+			t.Errorf("matchesLanguage(%q, %q) = %t, want %t", tt.tags, tt.language, got, tt.want)
+		}
+	}
+}
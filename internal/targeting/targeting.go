@@ -0,0 +1,238 @@
+// Package targeting picks the destination of a click among the targeting rules and
+// the weighted variants of a short URL.
+package targeting
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MaxRules is the number of targeting rules a short URL can have.
+const MaxRules = 50
+
+// IsHTTPURL reports whether s is an absolute http or https URL.
+func IsHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Rule sends the clicks matching all of its conditions to URL. Conditions that are
+// left empty match every click, a list matches if any of its values does.
+type Rule struct {
+	// ID identifies the rule in the recorded clicks, generated if left empty.
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// OS are operating system families, such as iOS, Android or Windows.
+	OS []string `json:"os,omitempty"`
+	// Devices are device types: desktop, mobile, tablet, bot or other.
+	Devices []string `json:"devices,omitempty"`
+	// Languages are language tags matched against the preferred language of the
+	// Accept-Language header. "en" matches every English variant, "en-US" only itself.
+	Languages []string `json:"languages,omitempty"`
+	// Countries are ISO 3166-1 alpha-2 codes. They never match when the country of
+	// the click is unknown, e.g. without a GeoIP database.
+	Countries []string `json:"countries,omitempty"`
+	// TimeOfDay restricts the rule to a daily time range.
+	TimeOfDay *TimeOfDay `json:"time_of_day,omitempty"`
+}
+
+// TimeOfDay is a daily time range from From, inclusive, to To, exclusive, both
+// formatted as HH:MM. Ranges with To before From span midnight.
+type TimeOfDay struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// TimeZone is the IANA time zone of the range, UTC if empty.
+	TimeZone string `json:"time_zone,omitempty"`
+}
+
+// Visit is what rules are matched against.
+type Visit struct {
+	OSFamily   string
+	DeviceType string
+	// Language is the preferred language of the visitor, see PreferredLanguage.
+	Language    string
+	CountryCode string
+	Time        time.Time
+}
+
+// Rules are the ordered targeting rules of a short URL, stored as JSON.
+type Rules []Rule
+
+// Match returns the first rule matching v, nil if none does.
+func (rules Rules) Match(v Visit) *Rule {
+	for i := range rules {
+		if rules[i].Matches(v) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// Matches reports whether all conditions of the rule match v.
+func (r *Rule) Matches(v Visit) bool {
+	if len(r.OS) > 0 && !containsFold(r.OS, v.OSFamily) {
+		return false
+	}
+	if len(r.Devices) > 0 && !containsFold(r.Devices, v.DeviceType) {
+		return false
+	}
+	if len(r.Countries) > 0 && !containsFold(r.Countries, v.CountryCode) {
+		return false
+	}
+	if len(r.Languages) > 0 && !matchesLanguage(r.Languages, v.Language) {
+		return false
+	}
+	if r.TimeOfDay != nil && !r.TimeOfDay.contains(v.Time) {
+		return false
+	}
+	return true
+}
+
+// Normalize validates the rules and generates the missing IDs.
+func (rules Rules) Normalize() error {
+	if len(rules) > MaxRules {
+		return fmt.Errorf("a short URL can't have more than %d targeting rules", MaxRules)
+	}
+
+	ids := map[string]bool{}
+	for i := range rules {
+		rule := &rules[i]
+		if rule.ID == "" {
+			rule.ID = uuid.NewString()
+		}
+		if ids[rule.ID] {
+			return fmt.Errorf("duplicate targeting rule id %q", rule.ID)
+		}
+		ids[rule.ID] = true
+
+		if !IsHTTPURL(rule.URL) {
+			return fmt.Errorf("targeting rule %q: url must be an absolute http or https URL", rule.ID)
+		}
+
+		if rule.TimeOfDay != nil {
+			if err := rule.TimeOfDay.validate(); err != nil {
+				return fmt.Errorf("targeting rule %q: %w", rule.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Value stores the rules as a JSON array.
+func (rules Rules) Value() (driver.Value, error) {
+	if rules == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(rules)
+	return string(data), err
+}
+
+// Scan reads rules stored as a JSON array.
+func (rules *Rules) Scan(src any) error {
+	switch src := src.(type) {
+	case []byte:
+		return json.Unmarshal(src, rules)
+	case string:
+		return json.Unmarshal([]byte(src), rules)
+	case nil:
+		*rules = nil
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into targeting rules", src)
+}
+
+func (t *TimeOfDay) validate() error {
+	from, errFrom := parseClock(t.From)
+	to, errTo := parseClock(t.To)
+	if errFrom != nil || errTo != nil {
+		return errors.New("time_of_day from and to must be formatted as HH:MM")
+	}
+	if from == to {
+		return errors.New("time_of_day from and to must differ")
+	}
+	if _, err := loadLocation(t.TimeZone); err != nil {
+		return fmt.Errorf("unknown time zone %q", t.TimeZone)
+	}
+	return nil
+}
+
+// contains reports whether the time of day of at, in the time zone of the range, is within it.
+func (t *TimeOfDay) contains(at time.Time) bool {
+	from, errFrom := parseClock(t.From)
+	to, errTo := parseClock(t.To)
+	loc, errLoc := loadLocation(t.TimeZone)
+	if errFrom != nil || errTo != nil || errLoc != nil {
+		return false
+	}
+
+	local := at.In(loc)
+	now := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute
+	if from < to {
+		return from <= now && now < to
+	}
+	return now >= from || now < to
+}
+
+// parseClock parses an HH:MM time of day into the duration since midnight.
+func parseClock(s string) (time.Duration, error) {
+	clock, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute, nil
+}
+
+// locations caches the time zones of the rules, loading one parses its tzdata.
+var locations sync.Map
+
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+func containsFold(values []string, value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesLanguage reports whether language is one of tags, or a variant of one of them.
+func matchesLanguage(tags []string, language string) bool {
+	if language == "" {
+		return false
+	}
+	for _, tag := range tags {
+		if strings.EqualFold(tag, language) {
+			return true
+		}
+		if len(language) > len(tag) && language[len(tag)] == '-' && strings.EqualFold(tag, language[:len(tag)]) {
+			return true
+		}
+	}
+	return false
+}
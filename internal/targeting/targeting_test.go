@@ -0,0 +1,217 @@
+package targeting
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsHTTPURL(t *testing.T) {
+	tests := map[string]bool{
+		"https://example.com/path?q=1": true,
+		"http://example.com":           true,
+		"HTTPS://EXAMPLE.COM":          true,
+		"ftp://example.com":            false,
+		"javascript:alert(1)":          false,
+		"//example.com":                false,
+		"/relative":                    false,
+		"https://":                     false,
+		"":                             false,
+		"https://exa mple.com":         false,
+	}
+	for s, want := range tests {
+		if got := IsHTTPURL(s); got != want {
+			t.Errorf("IsHTTPURL(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestRulesMatch(t *testing.T) {
+	rules := Rules{
+		{ID: "ios-fr", URL: "https://example.com/ios-fr", OS: []string{"iOS"}, Countries: []string{"FR"}},
+		{ID: "ios", URL: "https://example.com/ios", OS: []string{"iOS"}},
+		{ID: "mobile-en", URL: "https://example.com/mobile-en", Devices: []string{"mobile", "tablet"}, Languages: []string{"en"}},
+		{ID: "night", URL: "https://example.com/night", TimeOfDay: &TimeOfDay{From: "22:00", To: "06:00"}},
+	}
+	noon := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		visit Visit
+		want  string
+	}{
+		{"first matching rule wins", Visit{OSFamily: "iOS", CountryCode: "FR", Time: noon}, "ios-fr"},
+		{"later rule when an earlier condition fails", Visit{OSFamily: "iOS", CountryCode: "DE", Time: noon}, "ios"},
+		{"unknown country never matches", Visit{OSFamily: "iOS", Time: noon}, "ios"},
+		{"values match case insensitively", Visit{OSFamily: "ios", Time: noon}, "ios"},
+		{"any value of a list", Visit{OSFamily: "Android", DeviceType: "tablet", Language: "en-GB", Time: noon}, "mobile-en"},
+		{"all conditions must match", Visit{OSFamily: "Android", DeviceType: "tablet", Language: "de", Time: noon}, ""},
+		{"time of day", Visit{OSFamily: "Windows", Time: noon.Add(11 * time.Hour)}, "night"},
+		{"no match", Visit{OSFamily: "Windows", DeviceType: "desktop", Time: noon}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if rule := rules.Match(tt.visit); rule != nil {
+				got = rule.ID
+			}
+			if got != tt.want {
+				t.Errorf("Match(%+v) = %q, want %q", tt.visit, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeOfDayContains(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 5, 1, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name string
+		rng  TimeOfDay
+		at   time.Time
+		want bool
+	}{
+		{"within", TimeOfDay{From: "09:00", To: "17:00"}, at(12, 0), true},
+		{"from is inclusive", TimeOfDay{From: "09:00", To: "17:00"}, at(9, 0), true},
+		{"to is exclusive", TimeOfDay{From: "09:00", To: "17:00"}, at(17, 0), false},
+		{"before", TimeOfDay{From: "09:00", To: "17:00"}, at(8, 59), false},
+		{"across midnight before it", TimeOfDay{From: "22:00", To: "06:00"}, at(23, 30), true},
+		{"across midnight after it", TimeOfDay{From: "22:00", To: "06:00"}, at(5, 59), true},
+		{"across midnight at midnight", TimeOfDay{From: "22:00", To: "06:00"}, at(0, 0), true},
+		{"across midnight outside", TimeOfDay{From: "22:00", To: "06:00"}, at(12, 0), false},
+		{"across midnight to is exclusive", TimeOfDay{From: "22:00", To: "06:00"}, at(6, 0), false},
+		{"time zone", TimeOfDay{From: "09:00", To: "10:00", TimeZone: "Asia/Tokyo"}, at(0, 30), true},
+		{"time zone outside", TimeOfDay{From: "09:00", To: "10:00", TimeZone: "Asia/Tokyo"}, at(9, 30), false},
+		{"invalid range never matches", TimeOfDay{From: "9am", To: "10:00"}, at(9, 30), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rng.contains(tt.at); got != tt.want {
+				t.Errorf("%+v contains %s = %t, want %t", tt.rng, tt.at.Format(time.TimeOnly), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRulesNormalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   Rules
+		wantErr string
+	}{
+		{"valid", Rules{{ID: "a", URL: "https://example.com", TimeOfDay: &TimeOfDay{From: "22:00", To: "06:00", TimeZone: "Europe/Paris"}}}, ""},
+		{"duplicate id", Rules{{ID: "a", URL: "https://example.com"}, {ID: "a", URL: "https://example.org"}}, "duplicate"},
+		{"relative url", Rules{{ID: "a", URL: "/path"}}, "absolute"},
+		{"other scheme", Rules{{ID: "a", URL: "ftp://example.com"}}, "absolute"},
+		{"malformed time", Rules{{ID: "a", URL: "https://example.com", TimeOfDay: &TimeOfDay{From: "25:00", To: "06:00"}}}, "HH:MM"},
+		{"empty time range", Rules{{ID: "a", URL: "https://example.com", TimeOfDay: &TimeOfDay{From: "06:00", To: "06:00"}}}, "differ"},
+		{"unknown time zone", Rules{{ID: "a", URL: "https://example.com", TimeOfDay: &TimeOfDay{From: "06:00", To: "07:00", TimeZone: "Mars/Olympus"}}}, "time zone"},
+		{"too many", make(Rules, MaxRules+1), "more than"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rules.Normalize()
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Normalize: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Normalize error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRulesNormalizeGeneratesIDs(t *testing.T) {
+	rules := Rules{{URL: "https://example.com"}, {URL: "https://example.org"}}
+	if err := rules.Normalize(); err != nil {
+		t.Fatalf("Normalize: %v", err)
+	}
+	if rules[0].ID == "" || rules[1].ID == "" || rules[0].ID == rules[1].ID {
+		t.Errorf("generated ids %q and %q, want distinct non-empty ids", rules[0].ID, rules[1].ID)
+	}
+}
+
+func TestVariantsPick(t *testing.T) {
+	tests := []struct {
+		name     string
+		variants Variants
+		// want are the expected shares of the picks by variant ID.
+		want map[string]float64
+	}{
+		{"none", nil, nil},
+		{"all paused", Variants{{ID: "a", Weight: 0}, {ID: "b", Weight: 0}}, nil},
+		{"single", Variants{{ID: "a", Weight: 5}}, map[string]float64{"a": 1}},
+		{"paused never picked", Variants{{ID: "a", Weight: 1}, {ID: "b", Weight: 0}}, map[string]float64{"a": 1}},
+		{"weighted", Variants{{ID: "a", Weight: 3}, {ID: "b", Weight: 1}}, map[string]float64{"a": 0.75, "b": 0.25}},
+	}
+
+	const picks = 10000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counts := map[string]int{}
+			for range picks {
+				variant := tt.variants.Pick()
+				if variant == nil {
+					if tt.want != nil {
+						t.Fatal("Pick returned nil")
+					}
+					continue
+				}
+				if tt.want == nil {
+					t.Fatalf("Pick = %q, want nil", variant.ID)
+				}
+				counts[variant.ID]++
+			}
+
+			for id, count := range counts {
+				share := float64(count) / picks
+				if diff := share - tt.want[id]; diff < -0.05 || diff > 0.05 {
+					t.Errorf("variant %q picked %.3f of the time, want %.3f", id, share, tt.want[id])
+				}
+			}
+		})
+	}
+}
+
+func TestVariantsNormalize(t *testing.T) {
+	tests := []struct {
+		name     string
+		variants Variants
+		wantErr  string
+	}{
+		{"valid", Variants{{ID: "a", URL: "https://example.com", Weight: 1}, {ID: "b", URL: "https://example.org", Weight: 0}}, ""},
+		{"none", nil, ""},
+		{"duplicate id", Variants{{ID: "a", URL: "https://example.com", Weight: 1}, {ID: "a", URL: "https://example.org", Weight: 1}}, "duplicate"},
+		{"relative url", Variants{{ID: "a", URL: "example.com", Weight: 1}}, "absolute"},
+		{"negative weight", Variants{{ID: "a", URL: "https://example.com", Weight: -1}}, "negative"},
+		{"all paused", Variants{{ID: "a", URL: "https://example.com", Weight: 0}}, "positive weight"},
+		{"too many", make(Variants, MaxVariants+1), "more than"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.variants.Normalize()
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Normalize: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Normalize error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVariantsFind(t *testing.T) {
+	variants := Variants{{ID: "a", Weight: 1}, {ID: "b", Weight: 0}}
+
+	if v := variants.Find("a"); v == nil || v.ID != "a" {
+		t.Errorf("Find(a) = %v, want variant a", v)
+	}
+	if v := variants.Find("b"); v != nil {
+		t.Errorf("Find(b) = %v, want nil for a paused variant", v)
+	}
+	if v := variants.Find("c"); v != nil {
+		t.Errorf("Find(c) = %v, want nil", v)
+	}
+}
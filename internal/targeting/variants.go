@@ -0,0 +1,115 @@
+package targeting
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+
+	"github.com/google/uuid"
+)
+
+// MaxVariants is the number of variants a short URL can have.
+const MaxVariants = 20
+
+// Variant is one of the destinations the clicks of a short URL are split between.
+type Variant struct {
+	// ID identifies the variant in the recorded clicks, generated if left empty.
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Weight is the share of the clicks sent to the variant relative to the others,
+	// 0 pauses it.
+	Weight int `json:"weight"`
+}
+
+// Variants are the weighted destinations of a short URL, stored as JSON.
+type Variants []Variant
+
+// Pick chooses a variant at random in proportion to the weights, nil if there is
+// no variant with a positive weight.
+func (variants Variants) Pick() *Variant {
+	total := 0
+	for _, variant := range variants {
+		total += variant.Weight
+	}
+	if total <= 0 {
+		return nil
+	}
+
+	n := rand.IntN(total)
+	for i := range variants {
+		if n < variants[i].Weight {
+			return &variants[i]
+		}
+		n -= variants[i].Weight
+	}
+	return nil
+}
+
+// Find returns the variant with the given ID if it is served, nil otherwise.
+func (variants Variants) Find(id string) *Variant {
+	for i := range variants {
+		if variants[i].ID == id && variants[i].Weight > 0 {
+			return &variants[i]
+		}
+	}
+	return nil
+}
+
+// Normalize validates the variants and generates the missing IDs.
+func (variants Variants) Normalize() error {
+	if len(variants) > MaxVariants {
+		return fmt.Errorf("a short URL can't have more than %d variants", MaxVariants)
+	}
+
+	ids := map[string]bool{}
+	total := 0
+	for i := range variants {
+		variant := &variants[i]
+		if variant.ID == "" {
+			variant.ID = uuid.NewString()
+		}
+		if ids[variant.ID] {
+			return fmt.Errorf("duplicate variant id %q", variant.ID)
+		}
+		ids[variant.ID] = true
+
+		if !IsHTTPURL(variant.URL) {
+			return fmt.Errorf("variant %q: url must be an absolute http or https URL", variant.ID)
+		}
+
+		if variant.Weight < 0 {
+			return fmt.Errorf("variant %q: weight must not be negative", variant.ID)
+		}
+		total += variant.Weight
+	}
+
+	if len(variants) > 0 && total == 0 {
+		return errors.New("at least one variant must have a positive weight")
+	}
+	return nil
+}
+
+// Value stores the variants as a JSON array.
+func (variants Variants) Value() (driver.Value, error) {
+	if variants == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(variants)
+	return string(data), err
+}
+
+// Scan reads variants stored as a JSON array.
+func (variants *Variants) Scan(src any) error {
+	switch src := src.(type) {
+	case []byte:
+		return json.Unmarshal(src, variants)
+	case string:
+		return json.Unmarshal([]byte(src), variants)
+	case nil:
+		*variants = nil
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into variants", src)
+}
@@ -0,0 +1,173 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/nccapo/url-sh/config"
+	"github.com/nccapo/url-sh/internal/store"
+)
+
+// Headers sent with every delivery.
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Backoff between the attempts of a delivery, doubled after every failed attempt.
+const (
+	minBackoff = 30 * time.Second
+	maxBackoff = 6 * time.Hour
+)
+
+// expiredBatchSize is the number of expired short URLs queued at once.
+const expiredBatchSize = 50
+
+// maxErrorLength bounds the response body excerpt stored as the error of a delivery.
+const maxErrorLength = 512
+
+// Dispatcher sends the queued deliveries and queues the expiration of short URLs.
+type Dispatcher struct {
+	store  *store.Store
+	cfg    *config.WebhookConfig
+	secret string
+	client *http.Client
+}
+
+// NewDispatcher creates a Dispatcher sending the deliveries queued in st, signed with
+// keys derived from secret.
+func NewDispatcher(st *store.Store, cfg *config.WebhookConfig, secret string) *Dispatcher {
+	return &Dispatcher{
+		store:  st,
+		cfg:    cfg,
+		secret: secret,
+		client: newClient(cfg.Timeout),
+	}
+}
+
+// newClient returns the client deliveries are posted with. It only connects to public
+// addresses, without going through a proxy, which would be dialed in their place.
+func newClient(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{Timeout: timeout, Control: dialControl}).DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		// Redirects are reported as failures rather than followed to another host.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+// Run dispatches every cfg.PollInterval until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.Dispatch(ctx); err != nil && ctx.Err() == nil {
+			config.Warn("Dispatching webhooks failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dispatch queues the expiration of short URLs and sends the deliveries that are due.
+func (d *Dispatcher) Dispatch(ctx context.Context) error {
+	_, err := d.store.Webhooks.EnqueueExpired(ctx, EventLinkExpired, expiredBatchSize, func(link *store.URLShortener) ([]byte, error) {
+		return marshalPayload(EventLinkExpired, link.Expiration, link)
+	})
+	if err != nil {
+		return err
+	}
+
+	for {
+		// Deliveries are claimed one at a time, so that each is sent within its lease,
+		// which is longer than an attempt can take, and isn't claimed again by another
+		// replica while it waits behind the others.
+		deliveries, err := d.store.Webhooks.ClaimDue(ctx, 1, 2*d.cfg.Timeout)
+		if err != nil {
+			return err
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+
+		d.deliver(ctx, &deliveries[0])
+		if err := d.store.Webhooks.RecordAttempt(ctx, &deliveries[0]); err != nil {
+			return err
+		}
+	}
+}
+
+// deliver posts a delivery to its webhook and updates it with the outcome.
+func (d *Dispatcher) deliver(ctx context.Context, delivery *store.WebhookDelivery) {
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+
+	statusCode, err := d.post(ctx, delivery, now)
+	delivery.LastStatusCode = statusCode
+	if err == nil {
+		delivery.Status = store.DeliveryDelivered
+		delivery.LastError = ""
+		return
+	}
+
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= d.cfg.MaxAttempts {
+		delivery.Status = store.DeliveryFailed
+		return
+	}
+	delivery.NextAttemptAt = now.Add(backoff(delivery.Attempts))
+}
+
+// post sends the payload and returns the response status code, with an error
+// unless it is 2xx.
+func (d *Dispatcher) post(ctx context.Context, delivery *store.WebhookDelivery, now time.Time) (int, error) {
+	payload := []byte(delivery.Payload)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.WebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "url-sh-webhooks")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, delivery.IID.String())
+	req.Header.Set(HeaderSignature, Sign(SigningKey(d.secret, delivery.WebhookIID), now, payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorLength))
+		return resp.StatusCode, fmt.Errorf("unexpected status %s: %s", resp.Status, body)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+// backoff returns the delay before the attempt following the given number of failed ones.
+func backoff(attempts int) time.Duration {
+	delay := minBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
@@ -0,0 +1,277 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/nccapo/url-sh/config"
+	"github.com/nccapo/url-sh/internal/store"
+)
+
+// memoryWebhooks is an in-memory outbox of deliveries. The methods the dispatcher
+// doesn't use are left to the embedded, unconnected PostgresWebhooks.
+type memoryWebhooks struct {
+	*store.PostgresWebhooks
+
+	mu         sync.Mutex
+	deliveries []store.WebhookDelivery
+	// queued, if set, is waited on before a delivery is queued.
+	queued chan struct{}
+}
+
+func (m *memoryWebhooks) Enqueue(_ context.Context, _, event string, payload []byte) (int64, error) {
+	if m.queued != nil {
+		<-m.queued
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := int64(len(m.deliveries) + 1)
+	m.deliveries = append(m.deliveries, store.WebhookDelivery{
+		ID:            id,
+		IID:           uuid.New(),
+		Event:         event,
+		Payload:       string(payload),
+		Status:        store.DeliveryPending,
+		NextAttemptAt: time.Now(),
+	})
+	return id, nil
+}
+
+func (m *memoryWebhooks) EnqueueClickThreshold(ctx context.Context, ownerID, event string, _ int64, payload []byte) (int64, error) {
+	return m.Enqueue(ctx, ownerID, event, payload)
+}
+
+func (m *memoryWebhooks) EnqueueExpired(context.Context, string, int, func(*store.URLShortener) ([]byte, error)) (int, error) {
+	return 0, nil
+}
+
+func (m *memoryWebhooks) ClaimDue(_ context.Context, limit int, lease time.Duration) ([]store.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var claimed []store.WebhookDelivery
+	now := time.Now()
+	for i := range m.deliveries {
+		delivery := &m.deliveries[i]
+		if len(claimed) == limit || delivery.Status != store.DeliveryPending || delivery.NextAttemptAt.After(now) {
+			continue
+		}
+		delivery.NextAttemptAt = now.Add(lease)
+		claimed = append(claimed, *delivery)
+	}
+	return claimed, nil
+}
+
+func (m *memoryWebhooks) RecordAttempt(_ context.Context, delivery *store.WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.deliveries {
+		if m.deliveries[i].ID == delivery.ID {
+			m.deliveries[i] = *delivery
+			return nil
+		}
+	}
+	return store.ErrNotFound
+}
+
+func (m *memoryWebhooks) Replay(_ context.Context, webhookID int64, iid uuid.UUID) (*store.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.deliveries {
+		delivery := &m.deliveries[i]
+		if delivery.WebhookID == webhookID && delivery.IID == iid {
+			delivery.Status = store.DeliveryPending
+			delivery.Attempts = 0
+			delivery.NextAttemptAt = time.Now()
+			return delivery, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+// get returns the delivery with the given ID.
+func (m *memoryWebhooks) get(id int64) store.WebhookDelivery {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, delivery := range m.deliveries {
+		if delivery.ID == id {
+			return delivery
+		}
+	}
+	return store.WebhookDelivery{}
+}
+
+// elapse makes the next attempt of the delivery with the given ID due.
+func (m *memoryWebhooks) elapse(id int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.deliveries {
+		if m.deliveries[i].ID == id {
+			m.deliveries[i].NextAttemptAt = time.Now().Add(-time.Second)
+		}
+	}
+}
+
+const testSecret = "test-secret"
+
+// newTestDispatcher returns a dispatcher delivering to receiver, and the outbox holding
+// a single pending delivery.
+func newTestDispatcher(t *testing.T, receiver http.HandlerFunc) (*Dispatcher, *memoryWebhooks) {
+	t.Helper()
+
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+
+	outbox := &memoryWebhooks{deliveries: []store.WebhookDelivery{{
+		ID:            1,
+		IID:           uuid.New(),
+		WebhookID:     1,
+		WebhookIID:    uuid.New(),
+		WebhookURL:    server.URL,
+		Event:         EventLinkCreated,
+		Payload:       `{"event":"link.created"}`,
+		Status:        store.DeliveryPending,
+		NextAttemptAt: time.Now(),
+	}}}
+
+	d := NewDispatcher(&store.Store{Webhooks: outbox}, &config.WebhookConfig{
+		Timeout:      time.Second,
+		MaxAttempts:  3,
+		PollInterval: time.Second,
+	}, testSecret)
+	// The test server listens on a loopback address, which deliveries are refused to.
+	d.client = server.Client()
+	return d, outbox
+}
+
+func TestDispatchSignsDeliveries(t *testing.T) {
+	var (
+		header string
+		body   []byte
+	)
+	d, outbox := newTestDispatcher(t, func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get(HeaderSignature)
+		body, _ = io.ReadAll(r.Body)
+	})
+
+	if err := d.Dispatch(context.Background()); err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+
+	delivery := outbox.get(1)
+	if delivery.Status != store.DeliveryDelivered {
+		t.Fatalf("status = %q, want %q", delivery.Status, store.DeliveryDelivered)
+	}
+	if string(body) != delivery.Payload {
+		t.Errorf("body = %s, want %s", body, delivery.Payload)
+	}
+	want := Sign(SigningKey(testSecret, delivery.WebhookIID), *delivery.LastAttemptAt, body)
+	if header != want {
+		t.Errorf("%s = %q, want %q", HeaderSignature, header, want)
+	}
+}
+
+func TestDispatchRetriesWithBackoffUntilFailed(t *testing.T) {
+	var attempts int
+	d, outbox := newTestDispatcher(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+
+	for attempt := 1; attempt <= d.cfg.MaxAttempts; attempt++ {
+		if err := d.Dispatch(context.Background()); err != nil {
+			t.Fatalf("Dispatch: %v", err)
+		}
+
+		delivery := outbox.get(1)
+		if delivery.Attempts != attempt {
+			t.Fatalf("attempts = %d, want %d", delivery.Attempts, attempt)
+		}
+		if delivery.LastStatusCode != http.StatusServiceUnavailable || delivery.LastError == "" {
+			t.Errorf("attempt %d: last status code = %d, last error = %q", attempt, delivery.LastStatusCode, delivery.LastError)
+		}
+		if attempt == d.cfg.MaxAttempts {
+			if delivery.Status != store.DeliveryFailed {
+				t.Fatalf("status = %q, want %q", delivery.Status, store.DeliveryFailed)
+			}
+			break
+		}
+
+		if delivery.Status != store.DeliveryPending {
+			t.Fatalf("attempt %d: status = %q, want %q", attempt, delivery.Status, store.DeliveryPending)
+		}
+		if delay := delivery.NextAttemptAt.Sub(*delivery.LastAttemptAt); delay != backoff(attempt) {
+			t.Errorf("attempt %d: next attempt in %s, want %s", attempt, delay, backoff(attempt))
+		}
+
+		// Nothing is due before the backoff elapsed.
+		if err := d.Dispatch(context.Background()); err != nil {
+			t.Fatalf("Dispatch: %v", err)
+		}
+		if attempts != attempt {
+			t.Fatalf("receiver got %d attempts, want %d", attempts, attempt)
+		}
+		outbox.elapse(1)
+	}
+}
+
+func TestDispatchReplayedDelivery(t *testing.T) {
+	fail := true
+	d, outbox := newTestDispatcher(t, func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	d.cfg.MaxAttempts = 1
+
+	if err := d.Dispatch(context.Background()); err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+	delivery := outbox.get(1)
+	if delivery.Status != store.DeliveryFailed {
+		t.Fatalf("status = %q, want %q", delivery.Status, store.DeliveryFailed)
+	}
+
+	fail = false
+	if _, err := outbox.Replay(context.Background(), delivery.WebhookID, delivery.IID); err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if err := d.Dispatch(context.Background()); err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+
+	delivery = outbox.get(1)
+	if delivery.Status != store.DeliveryDelivered || delivery.Attempts != 1 || delivery.LastError != "" {
+		t.Errorf("replayed delivery: status = %q, attempts = %d, last error = %q", delivery.Status, delivery.Attempts, delivery.LastError)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, minBackoff},
+		{2, 2 * minBackoff},
+		{3, 4 * minBackoff},
+		{20, maxBackoff},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
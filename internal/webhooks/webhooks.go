@@ -0,0 +1,216 @@
+// Package webhooks notifies the webhooks registered by owners of the events of their short URLs.
+//
+// Events are queued in the webhook_deliveries outbox by a Notifier and sent by a
+// Dispatcher, which retries failed deliveries with exponential backoff.
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/nccapo/url-sh/config"
+	"github.com/nccapo/url-sh/internal/events"
+	"github.com/nccapo/url-sh/internal/metrics"
+	"github.com/nccapo/url-sh/internal/store"
+)
+
+// Events webhooks can subscribe to.
+const (
+	EventLinkCreated = "link.created"
+	EventLinkUpdated = "link.updated"
+	EventLinkDeleted = "link.deleted"
+	EventLinkExpired = "link.expired"
+	// EventLinkClicked is sent for every click recorded on a short URL.
+	EventLinkClicked = "link.clicked"
+	// EventClickThreshold is sent when the redirect count of a short URL reaches one
+	// of the click thresholds of the webhook.
+	EventClickThreshold = "link.click_threshold"
+)
+
+// IsEvent reports whether webhooks can subscribe to event.
+func IsEvent(event string) bool {
+	switch event {
+	case EventLinkCreated, EventLinkUpdated, EventLinkDeleted, EventLinkExpired, EventLinkClicked, EventClickThreshold:
+		return true
+	}
+	return false
+}
+
+// Payload is the JSON body posted to webhooks.
+type Payload struct {
+	Event      string    `json:"event"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Data       any       `json:"data"`
+}
+
+// clickData is the data of EventLinkClicked.
+type clickData struct {
+	Link  *store.URLShortener `json:"link"`
+	Click events.Click        `json:"click"`
+}
+
+// thresholdData is the data of EventClickThreshold.
+type thresholdData struct {
+	Link   *store.URLShortener `json:"link"`
+	Clicks int64               `json:"clicks"`
+}
+
+func marshalPayload(event string, at time.Time, data any) ([]byte, error) {
+	return json.Marshal(Payload{Event: event, OccurredAt: at.UTC(), Data: data})
+}
+
+// clickQueue is the number of clicks waiting to be queued for the webhooks. Clicks
+// recorded while it is full are dropped rather than holding up the redirects.
+const clickQueue = 1024
+
+// enqueueTimeout bounds queueing the deliveries of a single click.
+const enqueueTimeout = 5 * time.Second
+
+// pendingClick is a click waiting to be queued for the webhooks of its short URL.
+type pendingClick struct {
+	link  *store.URLShortener
+	click events.Click
+	count int
+}
+
+// Notifier queues the events of short URLs for the webhooks of their owner.
+// Short URLs created anonymously have no webhooks and are ignored.
+//
+// Clicks are queued in the background, so that redirects don't wait on the outbox.
+type Notifier struct {
+	store *store.Store
+
+	clicks  chan pendingClick
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewNotifier creates a Notifier queueing deliveries in st. Close stops queueing
+// the clicks.
+func NewNotifier(st *store.Store) *Notifier {
+	n := &Notifier{
+		store:   st,
+		clicks:  make(chan pendingClick, clickQueue),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go n.queueClicks()
+	return n
+}
+
+// LinkEvent queues one of the link lifecycle events with the short URL as data.
+func (n *Notifier) LinkEvent(ctx context.Context, event string, link *store.URLShortener) error {
+	if link.OwnerID == "" {
+		return nil
+	}
+
+	payload, err := marshalPayload(event, time.Now(), link)
+	if err != nil {
+		return err
+	}
+	_, err = n.store.Webhooks.Enqueue(ctx, link.OwnerID, event, payload)
+	return err
+}
+
+// Click queues EventLinkClicked for a recorded click, and EventClickThreshold if the
+// click brought the redirect count of the short URL to count. It never blocks: the
+// click is dropped if too many are waiting to be queued.
+func (n *Notifier) Click(link *store.URLShortener, click events.Click, count int) {
+	if link.OwnerID == "" {
+		return
+	}
+
+	select {
+	case n.clicks <- pendingClick{link: link, click: click, count: count}:
+	default:
+		metrics.WebhookClicksDroppedTotal.Inc()
+	}
+}
+
+// Close queues the clicks still waiting and stops. Clicks recorded afterwards are
+// not queued.
+func (n *Notifier) Close() {
+	close(n.stop)
+	<-n.stopped
+}
+
+// queueClicks queues the deliveries of the pending clicks until Close is called.
+func (n *Notifier) queueClicks() {
+	defer close(n.stopped)
+
+	for {
+		select {
+		case pending := <-n.clicks:
+			n.queueClick(pending)
+		case <-n.stop:
+			for {
+				select {
+				case pending := <-n.clicks:
+					n.queueClick(pending)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// queueClick queues the deliveries of a pending click, logging failures.
+func (n *Notifier) queueClick(pending pendingClick) {
+	ctx, cancel := context.WithTimeout(context.Background(), enqueueTimeout)
+	defer cancel()
+
+	if err := n.enqueueClick(ctx, pending.link, pending.click, pending.count); err != nil {
+		config.Warn("Queueing click webhooks of %s: %v", pending.link.ShortCode, err)
+	}
+}
+
+func (n *Notifier) enqueueClick(ctx context.Context, link *store.URLShortener, click events.Click, count int) error {
+	payload, err := marshalPayload(EventLinkClicked, click.AccessedAt, clickData{Link: link, Click: click})
+	if err != nil {
+		return err
+	}
+	if _, err := n.store.Webhooks.Enqueue(ctx, link.OwnerID, EventLinkClicked, payload); err != nil {
+		return err
+	}
+
+	// Bot clicks don't count towards the redirect count.
+	if click.IsBot {
+		return nil
+	}
+
+	payload, err = marshalPayload(EventClickThreshold, click.AccessedAt, thresholdData{Link: link, Clicks: int64(count)})
+	if err != nil {
+		return err
+	}
+	_, err = n.store.Webhooks.EnqueueClickThreshold(ctx, link.OwnerID, EventClickThreshold, int64(count), payload)
+	return err
+}
+
+// SigningKey returns the key the payloads of a webhook are signed with. It is derived
+// from the secret key of the service, so that it doesn't need to be stored.
+func SigningKey(secret string, webhook uuid.UUID) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("webhook:" + webhook.String()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign returns the value of the signature header of a payload sent at the given
+// time: "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<unix seconds>.<payload>">".
+// Receivers recompute the HMAC with the signing key of the webhook, and should
+// reject old timestamps to prevent replays.
+func Sign(key string, at time.Time, payload []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
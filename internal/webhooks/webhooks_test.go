@@ -0,0 +1,37 @@
+package webhooks
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nccapo/url-sh/internal/events"
+	"github.com/nccapo/url-sh/internal/store"
+)
+
+func TestNotifierQueuesClicksInTheBackground(t *testing.T) {
+	outbox := &memoryWebhooks{queued: make(chan struct{})}
+	n := NewNotifier(&store.Store{Webhooks: outbox})
+
+	owned := &store.URLShortener{ShortCode: "owned", OwnerID: "owner"}
+	anonymous := &store.URLShortener{ShortCode: "anonymous"}
+	now := time.Now()
+
+	// The outbox is held up, clicks must not wait on it.
+	n.Click(owned, events.Click{ShortCode: "owned", AccessedAt: now}, 1)
+	n.Click(owned, events.Click{ShortCode: "owned", AccessedAt: now, IsBot: true}, 1)
+	n.Click(anonymous, events.Click{ShortCode: "anonymous", AccessedAt: now}, 1)
+
+	close(outbox.queued)
+	n.Close()
+
+	var queued []string
+	for _, delivery := range outbox.deliveries {
+		queued = append(queued, delivery.Event)
+	}
+	// Bot clicks don't count towards the click thresholds.
+	want := []string{EventLinkClicked, EventClickThreshold, EventLinkClicked}
+	if !slices.Equal(queued, want) {
+		t.Errorf("queued %v, want %v", queued, want)
+	}
+}